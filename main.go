@@ -22,7 +22,7 @@ import (
 	"runtime"
 
 	"github.com/mikkeloscar/kube-metrics-adapter/pkg/server"
-	"k8s.io/apimachinery/pkg/util/wait"
+	genericapiserver "k8s.io/apiserver/pkg/server"
 	"k8s.io/apiserver/pkg/util/logs"
 )
 
@@ -34,7 +34,9 @@ func main() {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
 
-	cmd := server.NewCommandStartAdapterServer(wait.NeverStop)
+	// the stop channel is closed on SIGTERM or SIGINT, such that the
+	// adapter can shut down gracefully.
+	cmd := server.NewCommandStartAdapterServer(genericapiserver.SetupSignalHandler())
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 	if err := cmd.Execute(); err != nil {
 		panic(err)
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	aggregateConfKey      = "aggregate"
+	rangeAggregateConfKey = "range-aggregate"
+	defaultValueConfKey   = "default-value"
+)
+
+// aggregateFunc reduces a non-empty list of values to a single value.
+type aggregateFunc func(values []float64) float64
+
+// parseAggregateFunc returns the aggregate function for the specified name.
+// Supported functions are sum, max, min and avg. last is only supported if
+// allowLast is true, since it only makes sense for ordered values.
+func parseAggregateFunc(name string, allowLast bool) (aggregateFunc, error) {
+	switch name {
+	case "sum":
+		return sumValues, nil
+	case "max":
+		return maxValue, nil
+	case "min":
+		return minValue, nil
+	case "avg":
+		return avgValue, nil
+	case "last":
+		if allowLast {
+			return lastValue, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported aggregate function '%s'", name)
+}
+
+func sumValues(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func maxValue(values []float64) float64 {
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func minValue(values []float64) float64 {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func avgValue(values []float64) float64 {
+	return sumValues(values) / float64(len(values))
+}
+
+func lastValue(values []float64) float64 {
+	return values[len(values)-1]
+}
+
+// resultReducer reduces query results to a single value. It's configured by
+// the aggregate and default-value keys of a metric config. Without an
+// aggregate function several values are summed.
+type resultReducer struct {
+	aggregate    aggregateFunc
+	defaultValue *float64
+}
+
+// newResultReducer initializes a resultReducer from the metric config.
+func newResultReducer(config map[string]string) (*resultReducer, error) {
+	r := &resultReducer{
+		aggregate: sumValues,
+	}
+
+	if v, ok := config[aggregateConfKey]; ok {
+		aggregate, err := parseAggregateFunc(v, false)
+		if err != nil {
+			return nil, err
+		}
+		r.aggregate = aggregate
+	}
+
+	if v, ok := config[defaultValueConfKey]; ok {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse default value '%s': %v", v, err)
+		}
+		r.defaultValue = &value
+	}
+
+	return r, nil
+}
+
+// Reduce reduces a list of values to a single value. NaN values are ignored.
+// If no values are left the default value is returned if defined.
+func (r *resultReducer) Reduce(values []float64) (float64, error) {
+	filtered := make([]float64, 0, len(values))
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			filtered = append(filtered, v)
+		}
+	}
+
+	switch {
+	case len(filtered) == 0:
+		if r.defaultValue != nil {
+			return *r.defaultValue, nil
+		}
+		return 0, fmt.Errorf("no values")
+	case len(filtered) == 1:
+		return filtered[0], nil
+	default:
+		return r.aggregate(filtered), nil
+	}
+}
@@ -0,0 +1,29 @@
+package collector
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// allowedHosts are the hosts collectors may connect to if a server or url is
+// defined by an HPA. Without it anyone allowed to create HPAs could make the
+// adapter send requests to any host reachable from the cluster. An entry
+// either matches the host and port or only the host of the url.
+type allowedHosts []string
+
+// check returns an error if the host of the url isn't allowed.
+func (h allowedHosts) check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse url '%s': %v", rawURL, err)
+	}
+
+	for _, host := range h {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host '%s' of url '%s' is not allowed", u.Host, rawURL)
+}
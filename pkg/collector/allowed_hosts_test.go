@@ -0,0 +1,26 @@
+package collector
+
+import "testing"
+
+func TestAllowedHostsCheck(t *testing.T) {
+	hosts := allowedHosts{"influxdb.monitoring", "graphite.monitoring:8080"}
+
+	for _, tc := range []struct {
+		url     string
+		allowed bool
+	}{
+		{url: "http://influxdb.monitoring:8086", allowed: true},
+		{url: "http://INFLUXDB.monitoring", allowed: true},
+		{url: "http://graphite.monitoring:8080/render", allowed: true},
+		{url: "http://graphite.monitoring:9090", allowed: false},
+		{url: "http://169.254.169.254/latest/meta-data", allowed: false},
+		{url: "http://influxdb.monitoring.evil.com", allowed: false},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			err := hosts.check(tc.url)
+			if allowed := err == nil; allowed != tc.allowed {
+				t.Errorf("expected allowed %t, got %t: %v", tc.allowed, allowed, err)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
@@ -81,6 +82,7 @@ func NewAWSSQSCollector(sessions map[string]*session.Session, config *MetricConf
 	return &AWSSQSCollector{
 		sqs:        service,
 		interval:   interval,
+		region:     region,
 		queueURL:   aws.StringValue(resp.QueueUrl),
 		queueName:  name,
 		metricName: config.Name,
@@ -97,7 +99,12 @@ func (c *AWSSQSCollector) GetMetrics() ([]CollectedMetric, error) {
 
 	resp, err := c.sqs.GetQueueAttributes(params)
 	if err != nil {
-		return nil, err
+		// client errors like a deleted queue or missing permissions are
+		// specific to the collector.
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() < 500 {
+			return nil, err
+		}
+		return nil, backendError(err)
 	}
 
 	if v, ok := resp.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]; ok {
@@ -126,3 +133,13 @@ func (c *AWSSQSCollector) GetMetrics() ([]CollectedMetric, error) {
 func (c *AWSSQSCollector) Interval() time.Duration {
 	return c.interval
 }
+
+// Backend returns the SQS region of the collector.
+func (c *AWSSQSCollector) Backend() string {
+	return "aws-sqs:" + c.region
+}
+
+// Fingerprint identifies the SQS queue and the metric it's collected for.
+func (c *AWSSQSCollector) Fingerprint() string {
+	return fingerprint("aws-sqs", c.region, c.queueURL, c.metricType, c.metricName, c.labels, c.interval)
+}
@@ -1,7 +1,11 @@
 package collector
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -128,10 +132,9 @@ func (c *CollectorFactory) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAut
 			if kinds.Any != nil {
 				return kinds.Any.NewCollector(hpa, config, interval)
 			}
-			break
 		}
 
-		// else try to find a default plugin for this kind
+		// else try to find a plugin which is not specific to the kind
 		if plugin, ok := c.objectPlugins.Any.Named[config.CollectorName]; ok {
 			return plugin.NewCollector(hpa, config, interval)
 		}
@@ -180,6 +183,87 @@ type Collector interface {
 	Interval() time.Duration
 }
 
+// BackendCollector is a collector getting metrics from a backend which is
+// shared with other collectors, e.g. a Prometheus server. Collectors of the
+// same backend are paused together if the backend is failing.
+type BackendCollector interface {
+	Collector
+	// Backend identifies the backend e.g. prometheus:http://prometheus:9090.
+	Backend() string
+}
+
+// BackendError is an error of a BackendCollector caused by its backend, i.e.
+// the backend couldn't be reached, timed out or responded with a server
+// error. Only backend errors count as failures of the backend, other errors,
+// like an invalid query, are specific to the collector.
+type BackendError struct {
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return e.Err.Error()
+}
+
+// backendError wraps err in a BackendError. nil is returned if err is nil.
+func backendError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &BackendError{Err: err}
+}
+
+// httpStatusError returns the error of an unsuccessful HTTP response. Server
+// errors are backend errors.
+func httpStatusError(url string, resp *http.Response, body string) error {
+	err := fmt.Errorf("unsuccessful response from %s: %s", url, resp.Status)
+	if body != "" {
+		err = fmt.Errorf("%v: %s", err, body)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return backendError(err)
+	}
+	return err
+}
+
+// FingerprintCollector is a collector which can be shared by HPAs with the
+// same metric configuration. Collectors with the same fingerprint must
+// collect the same metrics, such that only one of them has to run.
+type FingerprintCollector interface {
+	Collector
+	Fingerprint() string
+}
+
+// ClosableCollector is a collector holding resources, e.g. a connection
+// shared with other collectors, which must be released once the collector is
+// stopped.
+type ClosableCollector interface {
+	Collector
+	Close()
+}
+
+// fingerprint returns a hash of the values identifying the configuration of
+// a collector. Maps are hashed in sorted key order.
+func fingerprint(values ...interface{}) string {
+	hash := sha256.New()
+	for _, value := range values {
+		switch value := value.(type) {
+		case map[string]string:
+			keys := make([]string, 0, len(value))
+			for k := range value {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(hash, "%q=%q,", k, value[k])
+			}
+		default:
+			fmt.Fprintf(hash, "%#v", value)
+		}
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 type MetricConfig struct {
 	MetricTypeName
 	CollectorName   string
@@ -218,6 +302,8 @@ func parseCustomMetricsAnnotations(annotations map[string]string) (map[MetricTyp
 			metricTypeName.Type = autoscalingv2beta1.PodsMetricSourceType
 		case "object":
 			metricTypeName.Type = autoscalingv2beta1.ObjectMetricSourceType
+		case "external":
+			metricTypeName.Type = autoscalingv2beta1.ExternalMetricSourceType
 		}
 
 		metricCollector := configs[3]
@@ -274,6 +360,7 @@ func ParseHPAMetrics(hpa *autoscalingv2beta1.HorizontalPodAutoscaler) ([]*Metric
 		}
 
 		var ref custom_metrics.ObjectReference
+		var metricLabels map[string]string
 		switch metric.Type {
 		case autoscalingv2beta1.PodsMetricSourceType:
 			typeName.Name = metric.Pods.MetricName
@@ -287,11 +374,18 @@ func ParseHPAMetrics(hpa *autoscalingv2beta1.HorizontalPodAutoscaler) ([]*Metric
 			}
 		case autoscalingv2beta1.ExternalMetricSourceType:
 			typeName.Name = metric.External.MetricName
+			if metric.External.MetricSelector != nil {
+				metricLabels = metric.External.MetricSelector.MatchLabels
+			}
 		}
 
 		if config, ok := configs[typeName]; ok {
+			// copy the config since several external metrics can
+			// have the same name but different labels.
+			config := *config
 			config.ObjectReference = ref
-			metricConfigs = append(metricConfigs, config)
+			config.Labels = metricLabels
+			metricConfigs = append(metricConfigs, &config)
 			continue
 		}
 
@@ -299,10 +393,7 @@ func ParseHPAMetrics(hpa *autoscalingv2beta1.HorizontalPodAutoscaler) ([]*Metric
 			MetricTypeName:  typeName,
 			ObjectReference: ref,
 			Config:          map[string]string{},
-		}
-
-		if metric.Type == autoscalingv2beta1.ExternalMetricSourceType {
-			config.Labels = metric.External.MetricSelector.MatchLabels
+			Labels:          metricLabels,
 		}
 		metricConfigs = append(metricConfigs, config)
 	}
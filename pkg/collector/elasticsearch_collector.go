@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ElasticsearchQueryMetric is the external metric for Elasticsearch
+	// queries.
+	ElasticsearchQueryMetric        = "elasticsearch-query"
+	elasticsearchIndexConfKey       = "index"
+	elasticsearchAggregationConfKey = "aggregation"
+)
+
+// NewElasticsearchCollectorPlugin initializes a new QueryCollectorPlugin for
+// getting metrics from Elasticsearch count or aggregation queries.
+func NewElasticsearchCollectorPlugin(client kubernetes.Interface, defaultServer string, allowedHosts []string) *QueryCollectorPlugin {
+	return newQueryCollectorPlugin(client, "elasticsearch", ElasticsearchQueryMetric, defaultServer, allowedHosts, newElasticsearchBackend)
+}
+
+// elasticsearchBackend runs queries against an Elasticsearch index. The query
+// is a JSON request body. Without an aggregation the number of matching
+// documents is counted, otherwise the value of the named aggregation is used.
+type elasticsearchBackend struct {
+	client      *queryHTTPClient
+	server      string
+	index       string
+	aggregation string
+	apiKey      string
+}
+
+func newElasticsearchBackend(server string, credentials map[string]string, config map[string]string) (queryBackend, error) {
+	index, ok := config[elasticsearchIndexConfKey]
+	if !ok {
+		return nil, fmt.Errorf("no elasticsearch index specified")
+	}
+
+	return &elasticsearchBackend{
+		client:      newQueryHTTPClient(credentials),
+		server:      strings.TrimSuffix(server, "/"),
+		index:       index,
+		aggregation: config[elasticsearchAggregationConfKey],
+		apiKey:      credentials["api-key"],
+	}, nil
+}
+
+// elasticsearchAggregation is a single-value metric aggregation or a bucket
+// aggregation.
+type elasticsearchAggregation struct {
+	Value   *float64 `json:"value"`
+	Buckets []struct {
+		DocCount float64 `json:"doc_count"`
+	} `json:"buckets"`
+}
+
+// Query runs a count query, or a search query if an aggregation is
+// configured.
+func (b *elasticsearchBackend) Query(query string) ([][]float64, error) {
+	header := http.Header{}
+	if b.apiKey != "" {
+		header.Set("Authorization", "ApiKey "+b.apiKey)
+	}
+
+	if b.aggregation == "" {
+		return b.count(query, header)
+	}
+	return b.aggregate(query, header)
+}
+
+// count returns the number of documents matching the query.
+func (b *elasticsearchBackend) count(query string, header http.Header) ([][]float64, error) {
+	countURL := fmt.Sprintf("%s/%s/_count", b.server, url.PathEscape(b.index))
+	data, err := b.client.do(http.MethodPost, countURL, "application/json", strings.NewReader(query), header)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Count *float64 `json:"count"`
+	}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse elasticsearch response: %v", err)
+	}
+
+	if response.Count == nil {
+		return nil, nil
+	}
+
+	return [][]float64{{*response.Count}}, nil
+}
+
+// aggregate returns the value of a single-value metric aggregation, or the
+// document count of each bucket of a bucket aggregation.
+func (b *elasticsearchBackend) aggregate(query string, header http.Header) ([][]float64, error) {
+	searchURL := fmt.Sprintf("%s/%s/_search?size=0", b.server, url.PathEscape(b.index))
+	data, err := b.client.do(http.MethodPost, searchURL, "application/json", strings.NewReader(query), header)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Aggregations map[string]elasticsearchAggregation `json:"aggregations"`
+	}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse elasticsearch response: %v", err)
+	}
+
+	aggregation, ok := response.Aggregations[b.aggregation]
+	if !ok {
+		return nil, fmt.Errorf("no aggregation '%s' in elasticsearch response", b.aggregation)
+	}
+
+	if aggregation.Value != nil {
+		return [][]float64{{*aggregation.Value}}, nil
+	}
+
+	result := make([][]float64, 0, len(aggregation.Buckets))
+	for _, bucket := range aggregation.Buckets {
+		result = append(result, []float64{bucket.DocCount})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// GraphiteQueryMetric is the external metric for Graphite queries.
+	GraphiteQueryMetric = "graphite-query"
+	graphiteFromConfKey = "from"
+	defaultGraphiteFrom = "-5min"
+)
+
+// NewGraphiteCollectorPlugin initializes a new QueryCollectorPlugin for
+// getting metrics from Graphite render targets.
+func NewGraphiteCollectorPlugin(client kubernetes.Interface, defaultServer string, allowedHosts []string) *QueryCollectorPlugin {
+	return newQueryCollectorPlugin(client, "graphite", GraphiteQueryMetric, defaultServer, allowedHosts, newGraphiteBackend)
+}
+
+// graphiteBackend runs queries via the Graphite render API. The query is a
+// render target.
+type graphiteBackend struct {
+	client *queryHTTPClient
+	server string
+	from   string
+}
+
+func newGraphiteBackend(server string, credentials map[string]string, config map[string]string) (queryBackend, error) {
+	b := &graphiteBackend{
+		client: newQueryHTTPClient(credentials),
+		server: strings.TrimSuffix(server, "/"),
+		from:   defaultGraphiteFrom,
+	}
+
+	if v, ok := config[graphiteFromConfKey]; ok {
+		b.from = v
+	}
+
+	return b, nil
+}
+
+// graphiteSeries is a series returned by the render API. Each datapoint is a
+// [value, timestamp] pair where the value can be null.
+type graphiteSeries struct {
+	Target     string        `json:"target"`
+	Datapoints [][2]*float64 `json:"datapoints"`
+}
+
+// Query renders the target in the JSON format.
+func (b *graphiteBackend) Query(query string) ([][]float64, error) {
+	params := url.Values{}
+	params.Set("target", query)
+	params.Set("format", "json")
+	params.Set("from", b.from)
+	params.Set("until", "now")
+
+	data, err := b.client.do(http.MethodPost, b.server+"/render", "application/x-www-form-urlencoded", strings.NewReader(params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []graphiteSeries
+	err = json.Unmarshal(data, &series)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse graphite response: %v", err)
+	}
+
+	result := make([][]float64, 0, len(series))
+	for _, s := range series {
+		points := make([]float64, 0, len(s.Datapoints))
+		for _, datapoint := range s.Datapoints {
+			if datapoint[0] != nil {
+				points = append(points, *datapoint[0])
+			}
+		}
+		result = append(result, points)
+	}
+
+	return result, nil
+}
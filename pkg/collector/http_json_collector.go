@@ -0,0 +1,232 @@
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oliveagle/jsonpath"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// HTTPJSONMetric is the external metric for values extracted from JSON
+	// HTTP endpoints.
+	HTTPJSONMetric               = "http-json"
+	httpJSONURLConfKey           = "url"
+	httpJSONKeyConfKey           = "json-key"
+	httpJSONHeadersSecretConfKey = "headers-secret"
+	httpJSONAuthSecretConfKey    = "auth-secret"
+)
+
+// HTTPJSONCollectorPlugin is a collector plugin for initializing collectors
+// for getting external metrics from JSON HTTP endpoints.
+type HTTPJSONCollectorPlugin struct {
+	client       kubernetes.Interface
+	allowedHosts []string
+}
+
+// NewHTTPJSONCollectorPlugin initializes a new HTTPJSONCollectorPlugin. The
+// urls defined by HPAs must be on one of the allowed hosts.
+func NewHTTPJSONCollectorPlugin(client kubernetes.Interface, allowedHosts []string) *HTTPJSONCollectorPlugin {
+	return &HTTPJSONCollectorPlugin{
+		client:       client,
+		allowedHosts: allowedHosts,
+	}
+}
+
+// NewCollector initializes a new HTTP JSON collector from the specified HPA.
+func (p *HTTPJSONCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Name {
+	case HTTPJSONMetric:
+		return NewHTTPJSONCollector(p.client, hpa, config, interval, p.allowedHosts)
+	}
+
+	return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+}
+
+// escapeURLValues escapes the values such that they can be used in the path
+// or the query of a URL without changing its structure. Spaces are escaped
+// as %20, which is valid in both.
+func escapeURLValues(values map[string]string) map[string]string {
+	escaped := make(map[string]string, len(values))
+	for k, v := range values {
+		escaped[k] = strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+	}
+	return escaped
+}
+
+// HTTPJSONCollector is a collector for getting an external metric by
+// requesting a JSON HTTP endpoint and extracting the value with a json path
+// query. It's the external metrics counterpart of the JSONPathMetricsGetter.
+type HTTPJSONCollector struct {
+	client            kubernetes.Interface
+	httpClient        *http.Client
+	url               string
+	host              string
+	jsonPath          *jsonpath.Compiled
+	namespace         string
+	headersSecretName string
+	authSecretName    string
+	interval          time.Duration
+	labels            map[string]string
+	metricName        string
+	metricType        autoscalingv2beta1.MetricSourceType
+}
+
+// NewHTTPJSONCollector initializes a new HTTPJSONCollector. The url is a
+// template rendered with the escaped labels of the metric selector and must
+// be on one of the allowed hosts. The endpoint is requested once to verify
+// the configuration.
+func NewHTTPJSONCollector(client kubernetes.Interface, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration, allowed []string) (*HTTPJSONCollector, error) {
+	urlTemplate, ok := config.Config[httpJSONURLConfKey]
+	if !ok {
+		return nil, fmt.Errorf("no url specified for metric %s", config.Name)
+	}
+
+	endpoint, err := renderQuery(urlTemplate, escapeURLValues(config.Labels))
+	if err != nil {
+		return nil, err
+	}
+
+	parsedURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url '%s': %v", endpoint, err)
+	}
+
+	err = allowedHosts(allowed).check(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonKey, ok := config.Config[httpJSONKeyConfKey]
+	if !ok {
+		return nil, fmt.Errorf("no json path definition specified")
+	}
+
+	jsonPath, err := jsonpath.Compile(jsonKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json path definition: %v", err)
+	}
+
+	c := &HTTPJSONCollector{
+		client: client,
+		httpClient: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: &http.Transport{},
+		},
+		url:               endpoint,
+		host:              parsedURL.Host,
+		jsonPath:          jsonPath,
+		namespace:         hpa.Namespace,
+		headersSecretName: config.Config[httpJSONHeadersSecretConfKey],
+		authSecretName:    config.Config[httpJSONAuthSecretConfKey],
+		interval:          interval,
+		labels:            config.Labels,
+		metricName:        config.Name,
+		metricType:        config.Type,
+	}
+
+	_, err = c.getValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetMetrics gets the value from the JSON endpoint.
+func (c *HTTPJSONCollector) GetMetrics() ([]CollectedMetric, error) {
+	value, err := c.getValue()
+	if err != nil {
+		return nil, err
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   c.metricName,
+			MetricLabels: c.labels,
+			Timestamp:    metav1.Time{Time: time.Now().UTC()},
+			Value:        *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// getValue requests the endpoint and extracts the value. The secrets are
+// read on every request such that they can be rotated.
+func (c *HTTPJSONCollector) getValue() (float64, error) {
+	request, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	if c.headersSecretName != "" {
+		headers, err := getSecretData(c.client, c.namespace, c.headersSecretName)
+		if err != nil {
+			return 0, err
+		}
+
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+	}
+
+	if c.authSecretName != "" {
+		auth, err := getSecretData(c.client, c.namespace, c.authSecretName)
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case auth["token"] != "":
+			request.Header.Set("Authorization", "Bearer "+auth["token"])
+		case auth["username"] != "":
+			request.SetBasicAuth(auth["username"], auth["password"])
+		default:
+			return 0, fmt.Errorf("secret '%s/%s' defines neither a token nor a username", c.namespace, c.authSecretName)
+		}
+	}
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return 0, backendError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, backendError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpStatusError(c.url, resp, "")
+	}
+
+	value, err := jsonPathValue(c.jsonPath, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get value from %s: %v", c.url, err)
+	}
+
+	return value, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *HTTPJSONCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the host of the JSON endpoint.
+func (c *HTTPJSONCollector) Backend() string {
+	return "http-json:" + c.host
+}
@@ -0,0 +1,256 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// InfluxDBQueryMetric is the external metric for InfluxDB queries.
+	InfluxDBQueryMetric            = "influxdb-query"
+	influxDBLanguageConfKey        = "language"
+	influxDBDatabaseConfKey        = "database"
+	influxDBOrganizationConfKey    = "organization"
+	influxDBLanguageInfluxQL       = "influxql"
+	influxDBLanguageFlux           = "flux"
+	influxDBFluxValueColumn        = "_value"
+	influxDBFluxTableColumn        = "table"
+	influxDBInfluxQLTimeColumnName = "time"
+)
+
+// NewInfluxDBCollectorPlugin initializes a new QueryCollectorPlugin for
+// getting metrics from InfluxQL or Flux queries.
+func NewInfluxDBCollectorPlugin(client kubernetes.Interface, defaultServer string, allowedHosts []string) *QueryCollectorPlugin {
+	return newQueryCollectorPlugin(client, "influxdb", InfluxDBQueryMetric, defaultServer, allowedHosts, newInfluxDBBackend)
+}
+
+// influxDBBackend runs InfluxQL queries via the /query API of InfluxDB 1.x or
+// Flux queries via the /api/v2/query API.
+type influxDBBackend struct {
+	client       *queryHTTPClient
+	server       string
+	language     string
+	database     string
+	organization string
+	token        string
+}
+
+func newInfluxDBBackend(server string, credentials map[string]string, config map[string]string) (queryBackend, error) {
+	b := &influxDBBackend{
+		client:       newQueryHTTPClient(credentials),
+		server:       strings.TrimSuffix(server, "/"),
+		language:     influxDBLanguageInfluxQL,
+		database:     config[influxDBDatabaseConfKey],
+		organization: config[influxDBOrganizationConfKey],
+		token:        credentials["token"],
+	}
+
+	if v, ok := config[influxDBLanguageConfKey]; ok {
+		b.language = v
+	}
+
+	switch b.language {
+	case influxDBLanguageInfluxQL:
+		if b.database == "" {
+			return nil, fmt.Errorf("no influxdb database specified")
+		}
+	case influxDBLanguageFlux:
+		if b.organization == "" {
+			return nil, fmt.Errorf("no influxdb organization specified")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported influxdb query language '%s'", b.language)
+	}
+
+	return b, nil
+}
+
+// Query runs the query in the configured language.
+func (b *influxDBBackend) Query(query string) ([][]float64, error) {
+	header := http.Header{}
+	if b.token != "" {
+		header.Set("Authorization", "Token "+b.token)
+	}
+
+	if b.language == influxDBLanguageFlux {
+		return b.queryFlux(query, header)
+	}
+	return b.queryInfluxQL(query, header)
+}
+
+// influxQLResponse is the response of the /query API.
+type influxQLResponse struct {
+	Results []struct {
+		Series []struct {
+			Columns []string        `json:"columns"`
+			Values  [][]interface{} `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+// queryInfluxQL runs an InfluxQL query. The first non-time column of each
+// series is used as value.
+func (b *influxDBBackend) queryInfluxQL(query string, header http.Header) ([][]float64, error) {
+	params := url.Values{}
+	params.Set("db", b.database)
+	params.Set("q", query)
+	params.Set("epoch", "s")
+
+	data, err := b.client.do(http.MethodPost, b.server+"/query", "application/x-www-form-urlencoded", strings.NewReader(params.Encode()), header)
+	if err != nil {
+		return nil, err
+	}
+
+	var response influxQLResponse
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse influxdb response: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+
+	var result [][]float64
+	for _, r := range response.Results {
+		if r.Error != "" {
+			return nil, fmt.Errorf("%s", r.Error)
+		}
+
+		for _, series := range r.Series {
+			column := -1
+			for i, name := range series.Columns {
+				if name != influxDBInfluxQLTimeColumnName {
+					column = i
+					break
+				}
+			}
+
+			if column < 0 {
+				continue
+			}
+
+			points := make([]float64, 0, len(series.Values))
+			for _, row := range series.Values {
+				if column >= len(row) {
+					continue
+				}
+
+				if v, ok := row[column].(float64); ok {
+					points = append(points, v)
+				}
+			}
+			result = append(result, points)
+		}
+	}
+
+	return result, nil
+}
+
+// queryFlux runs a Flux query. The response is CSV where each table of the
+// result is a series with the points in the _value column.
+func (b *influxDBBackend) queryFlux(query string, header http.Header) ([][]float64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": query,
+		"type":  "flux",
+		"dialect": map[string]interface{}{
+			"header":      true,
+			"annotations": []string{},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	header.Set("Accept", "application/csv")
+	queryURL := fmt.Sprintf("%s/api/v2/query?org=%s", b.server, url.QueryEscape(b.organization))
+
+	data, err := b.client.do(http.MethodPost, queryURL, "application/json", bytes.NewReader(body), header)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFluxCSV(data)
+}
+
+// parseFluxCSV parses the CSV result of a Flux query. A header row starts a
+// new result; series are identified by the table column.
+func parseFluxCSV(data []byte) ([][]float64, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	var result [][]float64
+	tables := map[string]int{}
+	valueColumn, tableColumn := -1, -1
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse influxdb response: %v", err)
+		}
+
+		if isFluxHeader(record) {
+			valueColumn, tableColumn = -1, -1
+			tables = map[string]int{}
+			for i, name := range record {
+				switch name {
+				case influxDBFluxValueColumn:
+					valueColumn = i
+				case influxDBFluxTableColumn:
+					tableColumn = i
+				}
+			}
+			continue
+		}
+
+		if valueColumn < 0 || valueColumn >= len(record) {
+			continue
+		}
+
+		table := ""
+		if tableColumn >= 0 && tableColumn < len(record) {
+			table = record[tableColumn]
+		}
+
+		i, ok := tables[table]
+		if !ok {
+			i = len(result)
+			tables[table] = i
+			result = append(result, nil)
+		}
+
+		value, err := strconv.ParseFloat(record[valueColumn], 64)
+		if err != nil {
+			// non-numeric or empty values are treated as null.
+			continue
+		}
+		result[i] = append(result[i], value)
+	}
+
+	return result, nil
+}
+
+// isFluxHeader returns true if the record is a header row of a Flux CSV
+// result.
+func isFluxHeader(record []string) bool {
+	for _, field := range record {
+		if field == influxDBFluxTableColumn {
+			return true
+		}
+	}
+	return false
+}
@@ -10,7 +10,10 @@ import (
 	"time"
 
 	"github.com/oliveagle/jsonpath"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // JSONPathMetricsGetter is a metrics getter which looks up pod metrics by
@@ -64,14 +67,19 @@ func (g *JSONPathMetricsGetter) GetMetric(pod *v1.Pod) (float64, error) {
 		return 0, err
 	}
 
-	// parse data
+	return jsonPathValue(g.jsonPath, data)
+}
+
+// jsonPathValue extracts a value from json data using the json path query.
+// The value must be a number.
+func jsonPathValue(jsonPath *jsonpath.Compiled, data []byte) (float64, error) {
 	var jsonData interface{}
-	err = json.Unmarshal(data, &jsonData)
+	err := json.Unmarshal(data, &jsonData)
 	if err != nil {
 		return 0, err
 	}
 
-	res, err := g.jsonPath.Lookup(jsonData)
+	res, err := jsonPath.Lookup(jsonData)
 	if err != nil {
 		return 0, err
 	}
@@ -94,11 +102,6 @@ func getPodMetrics(pod *v1.Pod, scheme, path string, port int) ([]byte, error) {
 		return nil, fmt.Errorf("pod %s/%s does not have a pod IP", pod.Namespace, pod.Namespace)
 	}
 
-	httpClient := &http.Client{
-		Timeout:   15 * time.Second,
-		Transport: &http.Transport{},
-	}
-
 	if scheme == "" {
 		scheme = "http"
 	}
@@ -109,7 +112,17 @@ func getPodMetrics(pod *v1.Pod, scheme, path string, port int) ([]byte, error) {
 		Path:   path,
 	}
 
-	request, err := http.NewRequest(http.MethodGet, metricsURL.String(), nil)
+	return getMetrics(metricsURL.String())
+}
+
+// getMetrics returns the content of a metrics endpoint.
+func getMetrics(metricsURL string) ([]byte, error) {
+	httpClient := &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &http.Transport{},
+	}
+
+	request, err := http.NewRequest(http.MethodGet, metricsURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -131,3 +144,129 @@ func getPodMetrics(pod *v1.Pod, scheme, path string, port int) ([]byte, error) {
 
 	return data, nil
 }
+
+// NewJSONPathObjectCollectorPlugin initializes a new ObjectCollectorPlugin
+// for getting object metrics with the JSONPathObjectMetricsGetter. Explicit
+// urls must be on one of the allowed hosts.
+func NewJSONPathObjectCollectorPlugin(client kubernetes.Interface, allowedHosts []string) *ObjectCollectorPlugin {
+	return NewObjectCollectorPlugin(client, func(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig) (ObjectMetricsGetter, error) {
+		return NewJSONPathObjectMetricsGetter(client, config.Config, allowedHosts)
+	})
+}
+
+// JSONPathObjectMetricsGetter is a metrics getter which looks up object
+// metrics by querying a json metrics endpoint and looking up the metric value
+// as defined by the json path query. The endpoint is either defined by an
+// explicit URL or derived from the cluster DNS name of the referenced
+// Service.
+type JSONPathObjectMetricsGetter struct {
+	client   kubernetes.Interface
+	jsonPath *jsonpath.Compiled
+	url      string
+	scheme   string
+	path     string
+	port     string
+}
+
+// NewJSONPathObjectMetricsGetter initializes a new
+// JSONPathObjectMetricsGetter. An explicit url must be on one of the allowed
+// hosts, without any only urls derived from Services can be used.
+func NewJSONPathObjectMetricsGetter(client kubernetes.Interface, config map[string]string, allowed []string) (*JSONPathObjectMetricsGetter, error) {
+	getter := &JSONPathObjectMetricsGetter{
+		client: client,
+		scheme: "http",
+	}
+
+	v, ok := config["json-key"]
+	if !ok {
+		return nil, fmt.Errorf("no json path definition specified")
+	}
+
+	pat, err := jsonpath.Compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json path definition: %v", err)
+	}
+	getter.jsonPath = pat
+
+	if v, ok := config["url"]; ok {
+		err := allowedHosts(allowed).check(v)
+		if err != nil {
+			return nil, err
+		}
+		getter.url = v
+		return getter, nil
+	}
+
+	if v, ok := config["scheme"]; ok {
+		getter.scheme = v
+	}
+
+	if v, ok := config["path"]; ok {
+		getter.path = v
+	}
+
+	v, ok = config["port"]
+	if !ok {
+		return nil, fmt.Errorf("either url or port must be specified")
+	}
+	getter.port = v
+
+	return getter, nil
+}
+
+// GetObjectMetric gets the metric for the referenced object by fetching json
+// metrics from the metrics endpoint and extracting the desired value using
+// the specified json path query.
+func (g *JSONPathObjectMetricsGetter) GetObjectMetric(namespace string, reference *autoscalingv2beta1.CrossVersionObjectReference) (float64, error) {
+	metricsURL, err := g.metricsURL(namespace, reference)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := getMetrics(metricsURL)
+	if err != nil {
+		return 0, err
+	}
+
+	return jsonPathValue(g.jsonPath, data)
+}
+
+// metricsURL returns the URL of the metrics endpoint. If no explicit URL is
+// defined it's derived from the cluster DNS name of the referenced Service.
+// A named port is resolved from the ports of the Service.
+func (g *JSONPathObjectMetricsGetter) metricsURL(namespace string, reference *autoscalingv2beta1.CrossVersionObjectReference) (string, error) {
+	if g.url != "" {
+		return g.url, nil
+	}
+
+	if reference.Kind != "Service" {
+		return "", fmt.Errorf("unable to derive metrics url for kind '%s', only Service is supported", reference.Kind)
+	}
+
+	port, err := strconv.Atoi(g.port)
+	if err != nil {
+		service, err := g.client.CoreV1().Services(namespace).Get(reference.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+
+		for _, servicePort := range service.Spec.Ports {
+			if servicePort.Name == g.port {
+				port = int(servicePort.Port)
+				break
+			}
+		}
+
+		if port == 0 {
+			return "", fmt.Errorf("port '%s' not found on service %s/%s", g.port, namespace, reference.Name)
+		}
+	}
+
+	metricsURL := url.URL{
+		Scheme: g.scheme,
+		Host:   fmt.Sprintf("%s.%s.svc:%d", reference.Name, namespace, port),
+		Path:   g.path,
+	}
+
+	return metricsURL.String(), nil
+}
@@ -0,0 +1,398 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	KafkaConsumerGroupLagMetric   = "kafka-consumer-group-lag"
+	kafkaTopicLabelKey            = "topic"
+	kafkaConsumerGroupLabelKey    = "consumer-group"
+	kafkaBrokersConfKey           = "brokers"
+	kafkaCredentialsSecretConfKey = "credentials-secret"
+	kafkaLagAggregateConfKey      = "lag-aggregate"
+	kafkaLagAggregateTotal        = "total"
+	kafkaLagAggregateMax          = "max"
+)
+
+// KafkaConfig defines how to connect and authenticate to Kafka brokers.
+type KafkaConfig struct {
+	// Brokers are the default brokers used if an HPA doesn't define
+	// brokers.
+	Brokers []string
+	// Version is the Kafka version of the brokers e.g. 1.0.0.
+	Version string
+	// TLS enables TLS for connections to the brokers.
+	TLS bool
+	// CAFile, CertFile and KeyFile configure server verification and
+	// client authentication for TLS.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	// SASLUsername and SASLPassword enable SASL/PLAIN authentication.
+	SASLUsername string
+	SASLPassword string
+}
+
+// saramaConfig returns the sarama client config for the Kafka config.
+func (c *KafkaConfig) saramaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "kube-metrics-adapter"
+
+	if c.Version != "" {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = version
+	}
+
+	if c.TLS {
+		tlsConfig, err := newTLSConfig(c.CAFile, c.CertFile, c.KeyFile, c.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if c.SASLUsername != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.User = c.SASLUsername
+		config.Net.SASL.Password = c.SASLPassword
+	}
+
+	return config, config.Validate()
+}
+
+// withoutIdentity returns a copy of the config without the client
+// certificate and SASL credentials. It's used for brokers other than the
+// default brokers, so the identity of the adapter isn't sent to brokers
+// defined by an HPA.
+func (c KafkaConfig) withoutIdentity() *KafkaConfig {
+	c.CertFile = ""
+	c.KeyFile = ""
+	c.SASLUsername = ""
+	c.SASLPassword = ""
+	return &c
+}
+
+// kafkaClientKey identifies a Kafka client. Clients are shared by all
+// collectors connecting to the same brokers with the same credentials.
+type kafkaClientKey struct {
+	brokers string
+	// secret is the namespace/name of the Secret with the SASL
+	// credentials, it's empty for the default identity and for
+	// connections without credentials.
+	secret   string
+	identity bool
+}
+
+// KafkaCollectorPlugin is a collector plugin for initializing collectors for
+// getting Kafka metrics. Clients are shared by all collectors using the same
+// brokers and credentials.
+type KafkaCollectorPlugin struct {
+	client kubernetes.Interface
+	// config is used for the default brokers, anonymousConfig for all
+	// other brokers.
+	config          *sarama.Config
+	anonymousConfig *sarama.Config
+	defaultBrokers  []string
+	clients         map[kafkaClientKey]sarama.Client
+	sync.Mutex
+}
+
+// NewKafkaCollectorPlugin initializes a new KafkaCollectorPlugin. The
+// configured client certificate and SASL credentials are only used for the
+// default brokers.
+func NewKafkaCollectorPlugin(client kubernetes.Interface, config *KafkaConfig) (*KafkaCollectorPlugin, error) {
+	saramaConfig, err := config.saramaConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka config: %v", err)
+	}
+
+	anonymousConfig, err := config.withoutIdentity().saramaConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka config: %v", err)
+	}
+
+	return &KafkaCollectorPlugin{
+		client:          client,
+		config:          saramaConfig,
+		anonymousConfig: anonymousConfig,
+		defaultBrokers:  config.Brokers,
+		clients:         map[kafkaClientKey]sarama.Client{},
+	}, nil
+}
+
+// NewCollector initializes a new Kafka collector from the specified HPA.
+func (p *KafkaCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Name {
+	case KafkaConsumerGroupLagMetric:
+		brokers := p.defaultBrokers
+		if v, ok := config.Config[kafkaBrokersConfKey]; ok {
+			brokers = strings.Split(v, ",")
+		}
+
+		client, err := p.kafkaClient(hpa.Namespace, brokers, config.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := NewKafkaConsumerGroupLagCollector(client, config, interval)
+		if err != nil {
+			return nil, err
+		}
+		c.brokers = brokersKey(brokers)
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+}
+
+// kafkaClient returns a client for the specified brokers. The default
+// identity is only used for the default brokers. Credentials for other
+// brokers are read from the Secret defined by the credentials-secret
+// annotation. A new client is created if none exists or the existing client
+// was closed.
+func (p *KafkaCollectorPlugin) kafkaClient(namespace string, brokers []string, config map[string]string) (sarama.Client, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no kafka brokers specified")
+	}
+
+	key := kafkaClientKey{brokers: brokersKey(brokers)}
+	saramaConfig := p.anonymousConfig
+
+	secretName, hasSecret := config[kafkaCredentialsSecretConfKey]
+	switch {
+	case hasSecret:
+		data, err := getSecretData(p.client, namespace, secretName)
+		if err != nil {
+			return nil, err
+		}
+
+		if data["username"] == "" {
+			return nil, fmt.Errorf("key 'username' not found in secret %s/%s", namespace, secretName)
+		}
+
+		withCredentials := *p.anonymousConfig
+		withCredentials.Net.SASL.Enable = true
+		withCredentials.Net.SASL.Handshake = true
+		withCredentials.Net.SASL.User = data["username"]
+		withCredentials.Net.SASL.Password = data["password"]
+		saramaConfig = &withCredentials
+		key.secret = namespace + "/" + secretName
+	case key.brokers == brokersKey(p.defaultBrokers):
+		saramaConfig = p.config
+		key.identity = true
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if client, ok := p.clients[key]; ok && !client.Closed() {
+		return client, nil
+	}
+
+	client, err := sarama.NewClient(brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka brokers %s: %v", key.brokers, err)
+	}
+	p.clients[key] = client
+
+	return client, nil
+}
+
+// brokersKey returns the sorted, comma separated list of brokers.
+func brokersKey(brokers []string) string {
+	sorted := make([]string, len(brokers))
+	copy(sorted, brokers)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// KafkaConsumerGroupLagCollector is a collector for getting the lag of a
+// consumer group for a topic. The lag is computed from the committed offsets
+// of the consumer group and the high-water marks of the partitions.
+type KafkaConsumerGroupLagCollector struct {
+	client        sarama.Client
+	brokers       string
+	topic         string
+	consumerGroup string
+	lagAggregate  string
+	interval      time.Duration
+	labels        map[string]string
+	metricName    string
+	metricType    autoscalingv2beta1.MetricSourceType
+}
+
+// NewKafkaConsumerGroupLagCollector initializes a new
+// KafkaConsumerGroupLagCollector.
+func NewKafkaConsumerGroupLagCollector(client sarama.Client, config *MetricConfig, interval time.Duration) (*KafkaConsumerGroupLagCollector, error) {
+	topic, ok := config.Labels[kafkaTopicLabelKey]
+	if !ok {
+		return nil, fmt.Errorf("kafka topic not specified on metric")
+	}
+
+	consumerGroup, ok := config.Labels[kafkaConsumerGroupLabelKey]
+	if !ok {
+		return nil, fmt.Errorf("kafka consumer group not specified on metric")
+	}
+
+	lagAggregate := kafkaLagAggregateTotal
+	if v, ok := config.Config[kafkaLagAggregateConfKey]; ok {
+		switch v {
+		case kafkaLagAggregateTotal, kafkaLagAggregateMax:
+			lagAggregate = v
+		default:
+			return nil, fmt.Errorf("unsupported lag aggregate '%s'", v)
+		}
+	}
+
+	_, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions for topic '%s': %v", topic, err)
+	}
+
+	return &KafkaConsumerGroupLagCollector{
+		client:        client,
+		topic:         topic,
+		consumerGroup: consumerGroup,
+		lagAggregate:  lagAggregate,
+		interval:      interval,
+		labels:        config.Labels,
+		metricName:    config.Name,
+		metricType:    config.Type,
+	}, nil
+}
+
+// GetMetrics gets the total lag or the maximum lag of a partition.
+func (c *KafkaConsumerGroupLagCollector) GetMetrics() ([]CollectedMetric, error) {
+	lags, err := c.partitionLags()
+	if err != nil {
+		return nil, err
+	}
+
+	var lag int64
+	for _, partitionLag := range lags {
+		switch c.lagAggregate {
+		case kafkaLagAggregateMax:
+			if partitionLag > lag {
+				lag = partitionLag
+			}
+		default:
+			lag += partitionLag
+		}
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   c.metricName,
+			MetricLabels: c.labels,
+			Timestamp:    metav1.Time{Time: time.Now().UTC()},
+			Value:        *resource.NewQuantity(lag, resource.DecimalSI),
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// partitionLags returns the lag of the consumer group for each partition of
+// the topic. Partitions without a committed offset are considered to be
+// consumed from the oldest offset.
+func (c *KafkaConsumerGroupLagCollector) partitionLags() (map[int32]int64, error) {
+	partitions, err := c.client.Partitions(c.topic)
+	if err != nil {
+		return nil, kafkaError(err, "failed to get partitions for topic '%s'", c.topic)
+	}
+
+	coordinator, err := c.client.Coordinator(c.consumerGroup)
+	if err != nil {
+		return nil, kafkaError(err, "failed to get coordinator for consumer group '%s'", c.consumerGroup)
+	}
+
+	request := &sarama.OffsetFetchRequest{
+		ConsumerGroup: c.consumerGroup,
+		Version:       1,
+	}
+	for _, partition := range partitions {
+		request.AddPartition(c.topic, partition)
+	}
+
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		// the coordinator may have moved, look it up again on the
+		// next run.
+		_ = c.client.RefreshCoordinator(c.consumerGroup)
+		return nil, kafkaError(err, "failed to fetch offsets for consumer group '%s'", c.consumerGroup)
+	}
+
+	lags := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		block := response.GetBlock(c.topic, partition)
+		if block == nil {
+			return nil, fmt.Errorf("no offset for partition %d of topic '%s'", partition, c.topic)
+		}
+
+		if block.Err != sarama.ErrNoError {
+			return nil, kafkaError(block.Err, "failed to fetch offset for partition %d of topic '%s'", partition, c.topic)
+		}
+
+		highWaterMark, err := c.client.GetOffset(c.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, kafkaError(err, "failed to get high-water mark for partition %d of topic '%s'", partition, c.topic)
+		}
+
+		offset := block.Offset
+		if offset < 0 {
+			offset, err = c.client.GetOffset(c.topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, kafkaError(err, "failed to get oldest offset for partition %d of topic '%s'", partition, c.topic)
+			}
+		}
+
+		lag := highWaterMark - offset
+		if lag < 0 {
+			lag = 0
+		}
+		lags[partition] = lag
+	}
+
+	return lags, nil
+}
+
+// kafkaError formats the error of a request to the brokers. Errors of the
+// topic or consumer group, e.g. an unknown topic, are specific to the
+// collector, all other errors are backend errors.
+func kafkaError(err error, format string, args ...interface{}) error {
+	wrapped := fmt.Errorf(format+": %v", append(args, err)...)
+	switch err {
+	case sarama.ErrUnknownTopicOrPartition, sarama.ErrInvalidGroupId, sarama.ErrTopicAuthorizationFailed, sarama.ErrGroupAuthorizationFailed:
+		return wrapped
+	}
+	return backendError(wrapped)
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *KafkaConsumerGroupLagCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the Kafka brokers of the collector.
+func (c *KafkaConsumerGroupLagCollector) Backend() string {
+	return "kafka:" + c.brokers
+}
@@ -0,0 +1,252 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testKafkaTopic         = "events"
+	testKafkaConsumerGroup = "event-processor"
+)
+
+// testKafkaPartition defines the offsets of a partition served by the mock
+// broker. A committed offset of -1 means the consumer group didn't commit an
+// offset for the partition yet.
+type testKafkaPartition struct {
+	oldest, newest, committed int64
+}
+
+// newTestKafkaBroker starts a mock broker which leads all partitions and
+// coordinates the consumer group. offsetErr is returned for the offsets of
+// the consumer group.
+func newTestKafkaBroker(t *testing.T, partitions map[int32]testKafkaPartition, offsetErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	offsets := sarama.NewMockOffsetResponse(t)
+	committed := sarama.NewMockOffsetFetchResponse(t)
+	for partition, p := range partitions {
+		metadata.SetLeader(testKafkaTopic, partition, broker.BrokerID())
+		offsets.SetOffset(testKafkaTopic, partition, sarama.OffsetOldest, p.oldest)
+		offsets.SetOffset(testKafkaTopic, partition, sarama.OffsetNewest, p.newest)
+		committed.SetOffset(testKafkaConsumerGroup, testKafkaTopic, partition, p.committed, "", offsetErr)
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"OffsetRequest":          offsets,
+		"OffsetFetchRequest":     committed,
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).SetCoordinator(sarama.CoordinatorGroup, testKafkaConsumerGroup, broker),
+	})
+
+	return broker
+}
+
+func newTestKafkaClient(t *testing.T, broker *sarama.MockBroker) sarama.Client {
+	config := sarama.NewConfig()
+	config.Metadata.Retry.Max = 0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatalf("failed to create kafka client: %v", err)
+	}
+	return client
+}
+
+func newTestKafkaMetricConfig(topic, lagAggregate string) *MetricConfig {
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: autoscalingv2beta1.ExternalMetricSourceType,
+			Name: KafkaConsumerGroupLagMetric,
+		},
+		Config: map[string]string{},
+		Labels: map[string]string{
+			kafkaTopicLabelKey:         topic,
+			kafkaConsumerGroupLabelKey: testKafkaConsumerGroup,
+		},
+	}
+	if lagAggregate != "" {
+		config.Config[kafkaLagAggregateConfKey] = lagAggregate
+	}
+	return config
+}
+
+func TestKafkaConsumerGroupLag(t *testing.T) {
+	partitions := map[int32]testKafkaPartition{
+		// lag of 10 behind the high-water mark.
+		0: {oldest: 0, newest: 100, committed: 90},
+		// fully consumed.
+		1: {oldest: 0, newest: 50, committed: 50},
+		// nothing committed, consumed from the oldest offset.
+		2: {oldest: 10, newest: 30, committed: -1},
+		// committed offset ahead of the high-water mark.
+		3: {oldest: 0, newest: 5, committed: 8},
+	}
+
+	for _, tc := range []struct {
+		lagAggregate string
+		lag          int64
+	}{
+		{"", 30},
+		{kafkaLagAggregateTotal, 30},
+		{kafkaLagAggregateMax, 20},
+	} {
+		t.Run("aggregate="+tc.lagAggregate, func(t *testing.T) {
+			broker := newTestKafkaBroker(t, partitions, sarama.ErrNoError)
+			defer broker.Close()
+			client := newTestKafkaClient(t, broker)
+			defer client.Close()
+
+			c, err := NewKafkaConsumerGroupLagCollector(client, newTestKafkaMetricConfig(testKafkaTopic, tc.lagAggregate), time.Minute)
+			if err != nil {
+				t.Fatalf("failed to create collector: %v", err)
+			}
+
+			lags, err := c.partitionLags()
+			if err != nil {
+				t.Fatalf("failed to get partition lags: %v", err)
+			}
+			expectedLags := map[int32]int64{0: 10, 1: 0, 2: 20, 3: 0}
+			for partition, lag := range expectedLags {
+				if lags[partition] != lag {
+					t.Errorf("expected lag %d for partition %d, got %d", lag, partition, lags[partition])
+				}
+			}
+
+			metrics, err := c.GetMetrics()
+			if err != nil {
+				t.Fatalf("failed to get metrics: %v", err)
+			}
+			if len(metrics) != 1 {
+				t.Fatalf("expected 1 metric, got %d", len(metrics))
+			}
+			if v := metrics[0].External.Value.Value(); v != tc.lag {
+				t.Errorf("expected lag %d, got %d", tc.lag, v)
+			}
+			if metrics[0].External.MetricLabels[kafkaTopicLabelKey] != testKafkaTopic {
+				t.Errorf("expected topic label %s, got %v", testKafkaTopic, metrics[0].External.MetricLabels)
+			}
+		})
+	}
+}
+
+func TestKafkaConsumerGroupLagUnsupportedAggregate(t *testing.T) {
+	broker := newTestKafkaBroker(t, map[int32]testKafkaPartition{0: {newest: 1}}, sarama.ErrNoError)
+	defer broker.Close()
+	client := newTestKafkaClient(t, broker)
+	defer client.Close()
+
+	_, err := NewKafkaConsumerGroupLagCollector(client, newTestKafkaMetricConfig(testKafkaTopic, "avg"), time.Minute)
+	if err == nil {
+		t.Error("expected error for unsupported lag aggregate")
+	}
+}
+
+func TestKafkaConsumerGroupLagUnknownTopic(t *testing.T) {
+	broker := newTestKafkaBroker(t, map[int32]testKafkaPartition{0: {newest: 1}}, sarama.ErrNoError)
+	defer broker.Close()
+	client := newTestKafkaClient(t, broker)
+	defer client.Close()
+
+	_, err := NewKafkaConsumerGroupLagCollector(client, newTestKafkaMetricConfig("unknown", ""), time.Minute)
+	if err == nil {
+		t.Fatal("expected error for unknown topic")
+	}
+}
+
+func TestKafkaConsumerGroupLagErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		offsetErr sarama.KError
+		backend   bool
+	}{
+		{"coordinator moved", sarama.ErrNotCoordinatorForConsumer, true},
+		{"not authorized", sarama.ErrGroupAuthorizationFailed, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			broker := newTestKafkaBroker(t, map[int32]testKafkaPartition{0: {newest: 10, committed: 5}}, tc.offsetErr)
+			defer broker.Close()
+			client := newTestKafkaClient(t, broker)
+			defer client.Close()
+
+			c, err := NewKafkaConsumerGroupLagCollector(client, newTestKafkaMetricConfig(testKafkaTopic, ""), time.Minute)
+			if err != nil {
+				t.Fatalf("failed to create collector: %v", err)
+			}
+
+			_, err = c.GetMetrics()
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if _, ok := err.(*BackendError); ok != tc.backend {
+				t.Errorf("expected backend error %t, got %T: %v", tc.backend, err, err)
+			}
+		})
+	}
+}
+
+func TestKafkaCollectorPluginBrokersOverride(t *testing.T) {
+	broker := newTestKafkaBroker(t, map[int32]testKafkaPartition{0: {newest: 1}}, sarama.ErrNoError)
+	defer broker.Close()
+
+	hpa := &autoscalingv2beta1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		secret string
+		err    bool
+	}{
+		{name: "without credentials"},
+		{name: "secret without access annotation", secret: "kafka", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "kafka", Namespace: "default"},
+				Data:       map[string][]byte{"username": []byte("user"), "password": []byte("secret")},
+			})
+
+			plugin, err := NewKafkaCollectorPlugin(client, &KafkaConfig{
+				Brokers:      []string{"kafka:9092"},
+				SASLUsername: "adapter",
+				SASLPassword: "adapter-secret",
+			})
+			if err != nil {
+				t.Fatalf("failed to create plugin: %v", err)
+			}
+
+			config := newTestKafkaMetricConfig(testKafkaTopic, "")
+			config.Config[kafkaBrokersConfKey] = broker.Addr()
+			if tc.secret != "" {
+				config.Config[kafkaCredentialsSecretConfKey] = tc.secret
+			}
+
+			_, err = plugin.NewCollector(hpa, config, time.Minute)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create collector: %v", err)
+			}
+
+			kafkaClient := plugin.clients[kafkaClientKey{brokers: broker.Addr()}]
+			if kafkaClient == nil {
+				t.Fatal("expected client without identity")
+			}
+			defer kafkaClient.Close()
+			if kafkaClient.Config().Net.SASL.Enable {
+				t.Error("expected default SASL credentials not to be used for other brokers")
+			}
+		})
+	}
+}
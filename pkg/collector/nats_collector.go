@@ -0,0 +1,314 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/go-nats"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	NATSJetStreamPendingMetric       = "nats-jetstream-pending"
+	NATSJetStreamAckPendingMetric    = "nats-jetstream-ack-pending"
+	natsStreamLabelKey               = "stream"
+	natsConsumerLabelKey             = "consumer"
+	natsURLConfKey                   = "url"
+	natsCredentialsSecretConfKey     = "credentials-secret"
+	natsConsumerInfoSubject          = "$JS.API.CONSUMER.INFO.%s.%s"
+	natsRequestTimeout               = 10 * time.Second
+	natsConsumerNotFoundErrorCode    = 404
+	natsConsumerInfoResponseTypeName = "io.nats.jetstream.api.v1.consumer_info_response"
+)
+
+// NATSMetrics are the external metrics supported by the NATS collector.
+var NATSMetrics = []string{
+	NATSJetStreamPendingMetric,
+	NATSJetStreamAckPendingMetric,
+}
+
+// NATSCredentials are credentials used to connect to a NATS server. Either a
+// token or a username and password can be defined.
+type NATSCredentials struct {
+	Token    string
+	Username string
+	Password string
+}
+
+func (c NATSCredentials) options() []nats.Option {
+	switch {
+	case c.Token != "":
+		return []nats.Option{nats.Token(c.Token)}
+	case c.Username != "":
+		return []nats.Option{nats.UserInfo(c.Username, c.Password)}
+	}
+	return nil
+}
+
+// natsConnKey identifies a NATS connection. Connections are shared by all
+// collectors connecting to the same server with the same credentials.
+type natsConnKey struct {
+	url         string
+	credentials NATSCredentials
+}
+
+// natsSharedConn is a connection shared by collectors. The connection is
+// closed once the last collector using it is closed.
+type natsSharedConn struct {
+	conn *nats.Conn
+	refs int
+}
+
+// NATSCollectorPlugin is a collector plugin for initializing collectors for
+// getting NATS JetStream consumer metrics.
+type NATSCollectorPlugin struct {
+	client             kubernetes.Interface
+	defaultURL         string
+	defaultCredentials NATSCredentials
+	conns              map[natsConnKey]*natsSharedConn
+	sync.Mutex
+}
+
+// NewNATSCollectorPlugin initializes a new NATSCollectorPlugin. The default
+// url is used if an HPA doesn't define one. The default credentials are only
+// used for the default url.
+func NewNATSCollectorPlugin(client kubernetes.Interface, defaultURL string, defaultCredentials NATSCredentials) *NATSCollectorPlugin {
+	return &NATSCollectorPlugin{
+		client:             client,
+		defaultURL:         defaultURL,
+		defaultCredentials: defaultCredentials,
+		conns:              map[natsConnKey]*natsSharedConn{},
+	}
+}
+
+// NewCollector initializes a new NATS collector from the specified HPA.
+func (p *NATSCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Name {
+	case NATSJetStreamPendingMetric, NATSJetStreamAckPendingMetric:
+		conn, key, err := p.conn(hpa.Namespace, config.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := NewNATSJetStreamCollector(conn, config, interval)
+		if err != nil {
+			p.release(key, conn)
+			return nil, err
+		}
+		c.release = func() { p.release(key, conn) }
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+}
+
+// conn returns a connection to the NATS server defined in the metric config
+// and the key of the connection. The connection must be released with the
+// key once it isn't used anymore.
+func (p *NATSCollectorPlugin) conn(namespace string, config map[string]string) (*nats.Conn, natsConnKey, error) {
+	key := natsConnKey{
+		url:         p.defaultURL,
+		credentials: p.defaultCredentials,
+	}
+
+	if v, ok := config[natsURLConfKey]; ok && v != p.defaultURL {
+		// the default credentials must not be sent to servers
+		// defined by an HPA.
+		key.url = v
+		key.credentials = NATSCredentials{}
+	}
+
+	if key.url == "" {
+		return nil, key, fmt.Errorf("no nats url specified")
+	}
+
+	if secretName, ok := config[natsCredentialsSecretConfKey]; ok {
+		data, err := getSecretData(p.client, namespace, secretName)
+		if err != nil {
+			return nil, key, err
+		}
+
+		key.credentials = NATSCredentials{
+			Token:    data["token"],
+			Username: data["username"],
+			Password: data["password"],
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if shared, ok := p.conns[key]; ok && !shared.conn.IsClosed() {
+		shared.refs++
+		return shared.conn, key, nil
+	}
+
+	options := append([]nats.Option{nats.Name("kube-metrics-adapter")}, key.credentials.options()...)
+	conn, err := nats.Connect(key.url, options...)
+	if err != nil {
+		return nil, key, fmt.Errorf("failed to connect to nats server %s: %v", key.url, err)
+	}
+	p.conns[key] = &natsSharedConn{conn: conn, refs: 1}
+
+	return conn, key, nil
+}
+
+// release releases a connection. The connection is closed once it's not used
+// by any collector.
+func (p *NATSCollectorPlugin) release(key natsConnKey, conn *nats.Conn) {
+	p.Lock()
+	defer p.Unlock()
+
+	shared, ok := p.conns[key]
+	if !ok || shared.conn != conn {
+		// the connection was closed and replaced.
+		conn.Close()
+		return
+	}
+
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+
+	shared.conn.Close()
+	delete(p.conns, key)
+}
+
+// natsConsumerInfo is the consumer info returned by the JetStream API.
+type natsConsumerInfo struct {
+	Type  string `json:"type"`
+	Error *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+	NumPending    int64 `json:"num_pending"`
+	NumAckPending int64 `json:"num_ack_pending"`
+}
+
+// NATSJetStreamCollector is a collector for getting the number of pending or
+// unacknowledged messages of a JetStream consumer.
+type NATSJetStreamCollector struct {
+	conn       *nats.Conn
+	stream     string
+	consumer   string
+	interval   time.Duration
+	labels     map[string]string
+	metricName string
+	metricType autoscalingv2beta1.MetricSourceType
+	release    func()
+	closeOnce  sync.Once
+}
+
+// NewNATSJetStreamCollector initializes a new NATSJetStreamCollector. The
+// consumer is looked up once to verify that it exists.
+func NewNATSJetStreamCollector(conn *nats.Conn, config *MetricConfig, interval time.Duration) (*NATSJetStreamCollector, error) {
+	stream, ok := config.Labels[natsStreamLabelKey]
+	if !ok {
+		return nil, fmt.Errorf("nats stream not specified on metric")
+	}
+
+	consumer, ok := config.Labels[natsConsumerLabelKey]
+	if !ok {
+		return nil, fmt.Errorf("nats consumer not specified on metric")
+	}
+
+	c := &NATSJetStreamCollector{
+		conn:       conn,
+		stream:     stream,
+		consumer:   consumer,
+		interval:   interval,
+		labels:     config.Labels,
+		metricName: config.Name,
+		metricType: config.Type,
+	}
+
+	_, err := c.consumerInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetMetrics gets the number of pending or unacknowledged messages of the
+// consumer.
+func (c *NATSJetStreamCollector) GetMetrics() ([]CollectedMetric, error) {
+	info, err := c.consumerInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	value := info.NumPending
+	if c.metricName == NATSJetStreamAckPendingMetric {
+		value = info.NumAckPending
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   c.metricName,
+			MetricLabels: c.labels,
+			Timestamp:    metav1.Time{Time: time.Now().UTC()},
+			Value:        *resource.NewQuantity(value, resource.DecimalSI),
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// consumerInfo requests the consumer info from the JetStream API.
+func (c *NATSJetStreamCollector) consumerInfo() (*natsConsumerInfo, error) {
+	msg, err := c.conn.Request(fmt.Sprintf(natsConsumerInfoSubject, c.stream, c.consumer), nil, natsRequestTimeout)
+	if err != nil {
+		return nil, backendError(fmt.Errorf("failed to get info for consumer '%s' of stream '%s': %v", c.consumer, c.stream, err))
+	}
+
+	var info natsConsumerInfo
+	err = json.Unmarshal(msg.Data, &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse info for consumer '%s' of stream '%s': %v", c.consumer, c.stream, err)
+	}
+
+	if info.Error != nil {
+		if info.Error.Code == natsConsumerNotFoundErrorCode {
+			return nil, fmt.Errorf("consumer '%s' of stream '%s' does not exist: %s", c.consumer, c.stream, info.Error.Description)
+		}
+		err := fmt.Errorf("failed to get info for consumer '%s' of stream '%s': %s (%d)", c.consumer, c.stream, info.Error.Description, info.Error.Code)
+		if info.Error.Code >= 500 {
+			return nil, backendError(err)
+		}
+		return nil, err
+	}
+
+	if info.Type != natsConsumerInfoResponseTypeName {
+		return nil, fmt.Errorf("unexpected response type '%s' for consumer '%s' of stream '%s'", info.Type, c.consumer, c.stream)
+	}
+
+	return &info, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *NATSJetStreamCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the NATS server of the collector.
+func (c *NATSJetStreamCollector) Backend() string {
+	return "nats:" + c.conn.Opts.Url
+}
+
+// Close releases the connection of the collector.
+func (c *NATSJetStreamCollector) Close() {
+	c.closeOnce.Do(func() {
+		if c.release != nil {
+			c.release()
+		}
+	})
+}
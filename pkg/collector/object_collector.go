@@ -1,20 +1,127 @@
 package collector
 
-import autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+import (
+	"fmt"
+	"time"
 
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+// ObjectMetricsGetter is an interface for getting a metric value for an
+// object referenced by an HPA.
 type ObjectMetricsGetter interface {
 	GetObjectMetric(namespace string, reference *autoscalingv2beta1.CrossVersionObjectReference) (float64, error)
 }
 
-// type PodCollector struct {
-// 	client           kubernetes.Interface
-// 	Getter           PodMetricsGetter
-// 	podLabelSelector string
-// 	namespace        string
-// 	metricName       string
-// 	interval         time.Duration
-// }
+// ObjectMetricsGetterFactory initializes an ObjectMetricsGetter for a metric
+// of an HPA.
+type ObjectMetricsGetterFactory func(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig) (ObjectMetricsGetter, error)
+
+// ObjectCollectorPlugin is a collector plugin for initializing object
+// collectors using a specific ObjectMetricsGetter.
+type ObjectCollectorPlugin struct {
+	client    kubernetes.Interface
+	newGetter ObjectMetricsGetterFactory
+}
+
+// NewObjectCollectorPlugin initializes a new ObjectCollectorPlugin.
+func NewObjectCollectorPlugin(client kubernetes.Interface, newGetter ObjectMetricsGetterFactory) *ObjectCollectorPlugin {
+	return &ObjectCollectorPlugin{
+		client:    client,
+		newGetter: newGetter,
+	}
+}
+
+// NewCollector initializes a new object collector from the specified HPA.
+func (p *ObjectCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	getter, err := p.newGetter(hpa, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewObjectCollector(p.client, getter, hpa, config, interval)
+}
+
+// ObjectCollector is a generic collector for getting a metric for the object
+// referenced by an HPA. The metric value is looked up by an
+// ObjectMetricsGetter.
+type ObjectCollector struct {
+	client          kubernetes.Interface
+	Getter          ObjectMetricsGetter
+	objectReference custom_metrics.ObjectReference
+	hpa             *autoscalingv2beta1.HorizontalPodAutoscaler
+	metricName      string
+	metricType      autoscalingv2beta1.MetricSourceType
+	interval        time.Duration
+	perReplica      bool
+}
+
+// NewObjectCollector initializes a new ObjectCollector.
+func NewObjectCollector(client kubernetes.Interface, getter ObjectMetricsGetter, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*ObjectCollector, error) {
+	if config.Type != autoscalingv2beta1.ObjectMetricSourceType {
+		return nil, fmt.Errorf("metric type %s not supported by object collector", config.Type)
+	}
 
-// func NewObjectCollector(client kubernetes.Interface, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, metricName string, config *MetricConfig, interval time.Duration) (Collector, error) {
-// 	switch
-// }
+	return &ObjectCollector{
+		client:          client,
+		Getter:          getter,
+		objectReference: config.ObjectReference,
+		hpa:             hpa,
+		metricName:      config.Name,
+		metricType:      config.Type,
+		interval:        interval,
+		perReplica:      config.PerReplica,
+	}, nil
+}
+
+// GetMetrics gets the metric for the referenced object.
+func (c *ObjectCollector) GetMetrics() ([]CollectedMetric, error) {
+	reference := &autoscalingv2beta1.CrossVersionObjectReference{
+		APIVersion: c.objectReference.APIVersion,
+		Kind:       c.objectReference.Kind,
+		Name:       c.objectReference.Name,
+	}
+
+	value, err := c.Getter.GetObjectMetric(c.objectReference.Namespace, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.perReplica {
+		// get current replicas for the targeted scale object. This is used to
+		// calculate an average metric instead of total.
+		// targetAverageValue will be available in Kubernetes v1.12
+		// https://github.com/kubernetes/kubernetes/pull/64097
+		replicas, err := targetRefReplicas(c.client, c.hpa)
+		if err != nil {
+			return nil, err
+		}
+
+		if replicas < 1 {
+			return nil, fmt.Errorf("unable to get average value for %d replicas", replicas)
+		}
+
+		value = value / float64(replicas)
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: c.objectReference,
+			MetricName:      c.metricName,
+			Timestamp:       metav1.Time{Time: time.Now().UTC()},
+			Value:           *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *ObjectCollector) Interval() time.Duration {
+	return c.interval
+}
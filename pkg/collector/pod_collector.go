@@ -58,16 +58,9 @@ func NewPodCollector(client kubernetes.Interface, hpa *autoscalingv2beta1.Horizo
 		podLabelSelector: selector,
 	}
 
-	var getter PodMetricsGetter
-	switch config.CollectorName {
-	case "json-path":
-		var err error
-		getter, err = NewJSONPathMetricsGetter(config.Config)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("format '%s' not supported", config.CollectorName)
+	getter, err := newPodMetricsGetter(config.CollectorName, config.Config)
+	if err != nil {
+		return nil, err
 	}
 
 	c.Getter = getter
@@ -75,6 +68,19 @@ func NewPodCollector(client kubernetes.Interface, hpa *autoscalingv2beta1.Horizo
 	return c, nil
 }
 
+// newPodMetricsGetter initializes a PodMetricsGetter for the specified
+// format.
+func newPodMetricsGetter(format string, config map[string]string) (PodMetricsGetter, error) {
+	switch format {
+	case "json-path":
+		return NewJSONPathMetricsGetter(config)
+	case "prometheus-text":
+		return NewPrometheusTextMetricsGetter(config)
+	default:
+		return nil, fmt.Errorf("format '%s' not supported", format)
+	}
+}
+
 func (c *PodCollector) GetMetrics() ([]CollectedMetric, error) {
 	opts := metav1.ListOptions{
 		LabelSelector: c.podLabelSelector,
@@ -122,20 +128,30 @@ func (c *PodCollector) Interval() time.Duration {
 }
 
 func getPodLabelSelector(client kubernetes.Interface, hpa *autoscalingv2beta1.HorizontalPodAutoscaler) (string, error) {
+	podLabels, err := getPodLabels(client, hpa)
+	if err != nil {
+		return "", err
+	}
+	return labels.Set(podLabels).String(), nil
+}
+
+// getPodLabels returns the labels used to select the pods of the HPA scale
+// target ref.
+func getPodLabels(client kubernetes.Interface, hpa *autoscalingv2beta1.HorizontalPodAutoscaler) (map[string]string, error) {
 	switch hpa.Spec.ScaleTargetRef.Kind {
 	case "Deployment":
 		deployment, err := client.AppsV1().Deployments(hpa.Namespace).Get(hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return labels.Set(deployment.Spec.Selector.MatchLabels).String(), nil
+		return deployment.Spec.Selector.MatchLabels, nil
 	case "StatefulSet":
 		sts, err := client.AppsV1().StatefulSets(hpa.Namespace).Get(hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return labels.Set(sts.Spec.Selector.MatchLabels).String(), nil
+		return sts.Spec.Selector.MatchLabels, nil
 	}
 
-	return "", fmt.Errorf("unable to get pod label selector for scale target ref '%s'", hpa.Spec.ScaleTargetRef.Kind)
+	return nil, fmt.Errorf("unable to get pod label selector for scale target ref '%s'", hpa.Spec.ScaleTargetRef.Kind)
 }
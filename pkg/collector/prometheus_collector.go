@@ -1,30 +1,47 @@
 package collector
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
 	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
 )
 
 type PrometheusCollectorPlugin struct {
 	promAPI promv1.API
 	client  kubernetes.Interface
+	server  string
 }
 
-func NewPrometheusCollectorPlugin(client kubernetes.Interface, prometheusServer string) (*PrometheusCollectorPlugin, error) {
+func NewPrometheusCollectorPlugin(client kubernetes.Interface, prometheusServer string, clientConfig *PrometheusClientConfig) (*PrometheusCollectorPlugin, error) {
+	var roundTripper http.RoundTripper = &http.Transport{}
+	if clientConfig != nil {
+		var err error
+		roundTripper, err = clientConfig.RoundTripper()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure prometheus client: %v", err)
+		}
+	}
+
 	cfg := api.Config{
 		Address:      prometheusServer,
-		RoundTripper: &http.Transport{},
+		RoundTripper: roundTripper,
 	}
 
 	promClient, err := api.NewClient(cfg)
@@ -35,23 +52,63 @@ func NewPrometheusCollectorPlugin(client kubernetes.Interface, prometheusServer
 	return &PrometheusCollectorPlugin{
 		client:  client,
 		promAPI: promv1.NewAPI(promClient),
+		server:  prometheusServer,
 	}, nil
 }
 
 func (p *PrometheusCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewPrometheusCollector(p.client, p.promAPI, hpa, config, interval)
+	c, err := NewPrometheusCollector(p.client, p.promAPI, hpa, config, interval)
+	if err != nil {
+		return nil, err
+	}
+	c.backend = p.backend()
+
+	// the scale target only matters for per replica metrics.
+	var scaleTarget autoscalingv2beta1.CrossVersionObjectReference
+	if c.perReplica {
+		scaleTarget = hpa.Spec.ScaleTargetRef
+	}
+	c.fingerprint = fingerprint("prometheus", c.backend, c.metricType, c.metricName,
+		c.objectReference, c.labels, c.query, config.Config, c.interval,
+		c.podLabel, c.podLabelSelector, hpa.Namespace, c.perReplica, scaleTarget)
+
+	return c, nil
 }
 
+// backend identifies the Prometheus server of the plugin.
+func (p *PrometheusCollectorPlugin) backend() string {
+	return "prometheus:" + p.server
+}
+
+const (
+	// PrometheusQueryMetric is the external metric for Prometheus queries.
+	// The query is defined in an annotation named after the query-name
+	// label of the metric selector.
+	PrometheusQueryMetric       = "prometheus-query"
+	prometheusQueryNameLabelKey = "query-name"
+	prometheusPodLabelConfKey   = "pod-label"
+	prometheusDryRunConfKey     = "dry-run"
+	defaultPrometheusPodLabel   = "pod"
+	prometheusDryRunTimeout     = 10 * time.Second
+)
+
 type PrometheusCollector struct {
-	client          kubernetes.Interface
-	promAPI         promv1.API
-	query           string
-	metricName      string
-	metricType      autoscalingv2beta1.MetricSourceType
-	objectReference custom_metrics.ObjectReference
-	interval        time.Duration
-	perReplica      bool
-	hpa             *autoscalingv2beta1.HorizontalPodAutoscaler
+	client           kubernetes.Interface
+	promAPI          promv1.API
+	query            string
+	metricName       string
+	metricType       autoscalingv2beta1.MetricSourceType
+	objectReference  custom_metrics.ObjectReference
+	interval         time.Duration
+	perReplica       bool
+	hpa              *autoscalingv2beta1.HorizontalPodAutoscaler
+	podLabel         string
+	podLabelSelector string
+	labels           map[string]string
+	reducer          *resultReducer
+	rangeAggregate   aggregateFunc
+	backend          string
+	fingerprint      string
 }
 
 func NewPrometheusCollector(client kubernetes.Interface, promAPI promv1.API, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*PrometheusCollector, error) {
@@ -64,41 +121,168 @@ func NewPrometheusCollector(client kubernetes.Interface, promAPI promv1.API, hpa
 		promAPI:         promAPI,
 		perReplica:      config.PerReplica,
 		hpa:             hpa,
+		labels:          config.Labels,
 	}
 
-	if v, ok := config.Config["query"]; ok {
-		// TODO: validate query
-		c.query = v
+	var query string
+	var data interface{}
+	if c.metricType == autoscalingv2beta1.ExternalMetricSourceType {
+		queryName, ok := config.Labels[prometheusQueryNameLabelKey]
+		if !ok {
+			return nil, fmt.Errorf("no %s label defined for metric %s", prometheusQueryNameLabelKey, config.Name)
+		}
+
+		query, ok = config.Config[queryName]
+		if !ok {
+			return nil, fmt.Errorf("no prometheus query defined for query name '%s'", queryName)
+		}
+
+		// external metric queries are rendered with the labels of
+		// the metric selector.
+		data = config.Labels
 	} else {
-		return nil, fmt.Errorf("no prometheus query defined")
+		var ok bool
+		query, ok = config.Config["query"]
+		if !ok {
+			return nil, fmt.Errorf("no prometheus query defined")
+		}
+
+		data = newQueryTemplateData(client, hpa, config)
 	}
 
-	return c, nil
-}
+	query, err := renderQuery(query, data)
+	if err != nil {
+		return nil, err
+	}
+	c.query = query
 
-func (c *PrometheusCollector) GetMetrics() ([]CollectedMetric, error) {
-	// TODO: use real context
-	value, err := c.promAPI.Query(context.Background(), c.query, time.Now().UTC())
+	c.reducer, err = newResultReducer(config.Config)
 	if err != nil {
 		return nil, err
 	}
 
-	var sampleValue model.SampleValue
-	switch value.Type() {
-	case model.ValVector:
-		samples := value.(model.Vector)
-		if len(samples) == 0 {
-			return nil, fmt.Errorf("query '%s' returned no samples", c.query)
+	c.rangeAggregate = lastValue
+	if v, ok := config.Config[rangeAggregateConfKey]; ok {
+		c.rangeAggregate, err = parseAggregateFunc(v, true)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		sampleValue = samples[0].Value
-	case model.ValScalar:
-		scalar := value.(*model.Scalar)
-		sampleValue = scalar.Value
+	if c.metricType == autoscalingv2beta1.PodsMetricSourceType {
+		// get pod selector based on HPA scale target ref
+		selector, err := getPodLabelSelector(client, hpa)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pod label selector: %v", err)
+		}
+		c.podLabelSelector = selector
+
+		c.podLabel = defaultPrometheusPodLabel
+		if v, ok := config.Config[prometheusPodLabelConfKey]; ok {
+			c.podLabel = v
+		}
+	}
+
+	err = c.validateQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := config.Config[prometheusDryRunConfKey]; ok && v == "true" {
+		err := c.dryRun()
+		if err != nil {
+			return nil, fmt.Errorf("dry run failed: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+// queryTemplateData is the data available when rendering a query template
+// defined for an HPA.
+type queryTemplateData struct {
+	client kubernetes.Interface
+	hpa    *autoscalingv2beta1.HorizontalPodAutoscaler
+
+	// Namespace is the namespace of the HPA.
+	Namespace string
+	// Name is the name of the HPA.
+	Name string
+	// ScaleTargetRef is the scale target of the HPA.
+	ScaleTargetRef autoscalingv2beta1.CrossVersionObjectReference
+	// Object is the target of an Object metric. It's empty for other
+	// metric types.
+	Object custom_metrics.ObjectReference
+}
+
+func newQueryTemplateData(client kubernetes.Interface, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig) *queryTemplateData {
+	return &queryTemplateData{
+		client:         client,
+		hpa:            hpa,
+		Namespace:      hpa.Namespace,
+		Name:           hpa.Name,
+		ScaleTargetRef: hpa.Spec.ScaleTargetRef,
+		Object:         config.ObjectReference,
+	}
+}
+
+// PodLabelSelector returns the pod label selector of the HPA scale target in
+// the Kubernetes label selector format e.g. 'application=foo,version=v1'.
+// The selector is only looked up if it's used in the template.
+func (d *queryTemplateData) PodLabelSelector() (string, error) {
+	return getPodLabelSelector(d.client, d.hpa)
+}
+
+// PodLabelMatchers returns the pod label selector of the HPA scale target as
+// Prometheus label matchers e.g. 'application="foo",version="v1"'.
+func (d *queryTemplateData) PodLabelMatchers() (string, error) {
+	podLabels, err := getPodLabels(d.client, d.hpa)
+	if err != nil {
+		return "", err
 	}
 
-	if sampleValue.String() == "NaN" {
-		return nil, fmt.Errorf("query '%s' returned no samples: %s", c.query, sampleValue.String())
+	matchers := make([]string, 0, len(podLabels))
+	for k, v := range podLabels {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(matchers)
+	return strings.Join(matchers, ","), nil
+}
+
+// renderQuery renders a query template with the specified data.
+func renderQuery(query string, data interface{}) (string, error) {
+	tmpl, err := template.New("query").Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query template '%s': %v", query, err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render query template '%s': %v", query, err)
+	}
+
+	return buf.String(), nil
+}
+
+func (c *PrometheusCollector) GetMetrics() ([]CollectedMetric, error) {
+	// the query must not take longer than the interval, otherwise runs of
+	// the collector would pile up behind a slow Prometheus server.
+	ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+	defer cancel()
+
+	value, err := c.promAPI.Query(ctx, c.query, time.Now().UTC())
+	if err != nil {
+		return nil, prometheusError(err)
+	}
+
+	if c.metricType == autoscalingv2beta1.PodsMetricSourceType {
+		return c.podMetrics(value)
+	}
+
+	sampleValue, err := c.sampleValue(value)
+	if err != nil {
+		return nil, err
 	}
 
 	if c.perReplica {
@@ -110,22 +294,243 @@ func (c *PrometheusCollector) GetMetrics() ([]CollectedMetric, error) {
 		if err != nil {
 			return nil, err
 		}
+
+		if replicas < 1 {
+			return nil, fmt.Errorf("unable to get average value for %d replicas", replicas)
+		}
+
 		sampleValue = model.SampleValue(float64(sampleValue) / float64(replicas))
 	}
 
-	metricValue := CollectedMetric{
-		Type: c.metricType,
-		Custom: custom_metrics.MetricValue{
-			DescribedObject: c.objectReference,
-			MetricName:      c.metricName,
-			Timestamp:       metav1.Time{Time: time.Now().UTC()},
-			Value:           *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
-		},
+	var metricValue CollectedMetric
+	switch c.metricType {
+	case autoscalingv2beta1.ObjectMetricSourceType:
+		metricValue = CollectedMetric{
+			Type: c.metricType,
+			Custom: custom_metrics.MetricValue{
+				DescribedObject: c.objectReference,
+				MetricName:      c.metricName,
+				Timestamp:       metav1.Time{Time: time.Now().UTC()},
+				Value:           *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
+			},
+		}
+	case autoscalingv2beta1.ExternalMetricSourceType:
+		metricValue = CollectedMetric{
+			Type: c.metricType,
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   c.metricName,
+				MetricLabels: c.labels,
+				Timestamp:    metav1.Time{Time: time.Now().UTC()},
+				Value:        *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
+			},
+		}
 	}
 
 	return []CollectedMetric{metricValue}, nil
 }
 
+// sampleValue reduces a query result to a single sample value.
+func (c *PrometheusCollector) sampleValue(value model.Value) (model.SampleValue, error) {
+	var values []float64
+	switch value.Type() {
+	case model.ValScalar:
+		scalar := value.(*model.Scalar)
+		values = []float64{float64(scalar.Value)}
+	case model.ValVector, model.ValMatrix:
+		for _, series := range c.seriesValues(value) {
+			values = append(values, series.value)
+		}
+	default:
+		return 0, fmt.Errorf("query '%s' returned unsupported result type %s", c.query, value.Type())
+	}
+
+	sampleValue, err := c.reducer.Reduce(values)
+	if err != nil {
+		return 0, fmt.Errorf("query '%s' returned no usable samples: %v", c.query, err)
+	}
+
+	return model.SampleValue(sampleValue), nil
+}
+
+// seriesValue is the value of a single series of a query result.
+type seriesValue struct {
+	metric model.Metric
+	value  float64
+}
+
+// seriesValues returns a value for each series of a vector or matrix. The
+// points of each series of a matrix are reduced with the range aggregate
+// function.
+func (c *PrometheusCollector) seriesValues(value model.Value) []seriesValue {
+	var values []seriesValue
+	switch value := value.(type) {
+	case model.Vector:
+		for _, sample := range value {
+			values = append(values, seriesValue{
+				metric: sample.Metric,
+				value:  float64(sample.Value),
+			})
+		}
+	case model.Matrix:
+		for _, stream := range value {
+			if len(stream.Values) == 0 {
+				continue
+			}
+
+			points := make([]float64, 0, len(stream.Values))
+			for _, point := range stream.Values {
+				points = append(points, float64(point.Value))
+			}
+
+			values = append(values, seriesValue{
+				metric: stream.Metric,
+				value:  c.rangeAggregate(points),
+			})
+		}
+	}
+	return values
+}
+
+// podMetrics maps a vector with one series per pod, identified by the pod
+// label, to metrics for the pods of the HPA scale target.
+func (c *PrometheusCollector) podMetrics(value model.Value) ([]CollectedMetric, error) {
+	podValues, err := c.podSampleValues(value)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := metav1.ListOptions{
+		LabelSelector: c.podLabelSelector,
+	}
+
+	pods, err := c.client.CoreV1().Pods(c.hpa.Namespace).List(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]CollectedMetric, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		sampleValue, err := c.reducer.Reduce(podValues[pod.Name])
+		if err != nil {
+			glog.V(2).Infof("No value for pod '%s/%s' from query '%s': %v", pod.Namespace, pod.Name, c.query, err)
+			continue
+		}
+
+		metricValue := CollectedMetric{
+			Type: c.metricType,
+			Custom: custom_metrics.MetricValue{
+				DescribedObject: custom_metrics.ObjectReference{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       pod.Name,
+					Namespace:  pod.Namespace,
+				},
+				MetricName: c.metricName,
+				Timestamp:  metav1.Time{Time: time.Now().UTC()},
+				Value:      *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
+			},
+			Labels: pod.Labels,
+		}
+
+		values = append(values, metricValue)
+	}
+
+	return values, nil
+}
+
+// podSampleValues returns the values of a query result grouped by pod name.
+func (c *PrometheusCollector) podSampleValues(value model.Value) (map[string][]float64, error) {
+	switch value.Type() {
+	case model.ValVector, model.ValMatrix:
+	default:
+		return nil, fmt.Errorf("query '%s' returned %s, expected a vector with one series per pod", c.query, value.Type())
+	}
+
+	podValues := make(map[string][]float64)
+	for _, series := range c.seriesValues(value) {
+		podName, ok := series.metric[model.LabelName(c.podLabel)]
+		if !ok {
+			glog.Warningf("Series %s returned by query '%s' has no '%s' label", series.metric, c.query, c.podLabel)
+			continue
+		}
+		podValues[string(podName)] = append(podValues[string(podName)], series.value)
+	}
+
+	return podValues, nil
+}
+
+// validateQuery parses the query and checks that it returns a result type
+// supported for the metric type.
+func (c *PrometheusCollector) validateQuery() error {
+	expr, err := promql.ParseExpr(c.query)
+	if err != nil {
+		return fmt.Errorf("failed to parse query '%s': %v", c.query, err)
+	}
+
+	switch expr.Type() {
+	case promql.ValueTypeVector, promql.ValueTypeMatrix:
+		return nil
+	case promql.ValueTypeScalar:
+		if c.metricType == autoscalingv2beta1.PodsMetricSourceType {
+			return fmt.Errorf("query '%s' returns a scalar, expected a vector with one series per pod", c.query)
+		}
+		return nil
+	default:
+		return fmt.Errorf("query '%s' returns unsupported result type %s", c.query, expr.Type())
+	}
+}
+
+// prometheusError returns the error of a failed query. Invalid queries and
+// queries failing to execute are specific to the collector, all other
+// errors, like timeouts or server errors, are backend errors.
+func prometheusError(err error) error {
+	if apiErr, ok := err.(*promv1.Error); ok {
+		switch apiErr.Type {
+		case promv1.ErrBadData, promv1.ErrExec:
+			return err
+		}
+	}
+	return backendError(err)
+}
+
+// dryRun runs the query once and checks that the result has a shape
+// supported for the metric type.
+func (c *PrometheusCollector) dryRun() error {
+	ctx, cancel := context.WithTimeout(context.Background(), prometheusDryRunTimeout)
+	defer cancel()
+
+	value, err := c.promAPI.Query(ctx, c.query, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to run query '%s': %v", c.query, err)
+	}
+
+	if c.metricType == autoscalingv2beta1.PodsMetricSourceType {
+		podValues, err := c.podSampleValues(value)
+		if err != nil {
+			return err
+		}
+
+		if len(podValues) == 0 && c.reducer.defaultValue == nil {
+			return fmt.Errorf("query '%s' returned no series with a '%s' label", c.query, c.podLabel)
+		}
+		return nil
+	}
+
+	_, err = c.sampleValue(value)
+	return err
+}
+
 func (c *PrometheusCollector) Interval() time.Duration {
 	return c.interval
 }
+
+// Backend returns the Prometheus server of the collector.
+func (c *PrometheusCollector) Backend() string {
+	return c.backend
+}
+
+// Fingerprint identifies the query and the metric it's collected for.
+// Collectors which are not created by the plugin are not shared.
+func (c *PrometheusCollector) Fingerprint() string {
+	return c.fingerprint
+}
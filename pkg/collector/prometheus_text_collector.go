@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/api/core/v1"
+)
+
+// PrometheusTextMetricsGetter is a metrics getter which looks up pod metrics
+// by querying the pods metrics endpoint exposing metrics in the Prometheus
+// text format. The values of all series of the metric are summed.
+type PrometheusTextMetricsGetter struct {
+	metric string
+	scheme string
+	path   string
+	port   int
+}
+
+// NewPrometheusTextMetricsGetter initializes a new
+// PrometheusTextMetricsGetter.
+func NewPrometheusTextMetricsGetter(config map[string]string) (*PrometheusTextMetricsGetter, error) {
+	getter := &PrometheusTextMetricsGetter{
+		path: "/metrics",
+	}
+
+	v, ok := config["metric"]
+	if !ok {
+		return nil, fmt.Errorf("no metric name specified")
+	}
+	getter.metric = v
+
+	if v, ok := config["scheme"]; ok {
+		getter.scheme = v
+	}
+
+	if v, ok := config["path"]; ok {
+		getter.path = v
+	}
+
+	if v, ok := config["port"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		getter.port = n
+	}
+
+	return getter, nil
+}
+
+// GetMetric gets metric from pod by fetching Prometheus metrics from the pods
+// metric endpoint and summing the values of the specified metric.
+func (g *PrometheusTextMetricsGetter) GetMetric(pod *v1.Pod) (float64, error) {
+	data, err := getPodMetrics(pod, g.scheme, g.path, g.port)
+	if err != nil {
+		return 0, err
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse metrics: %v", err)
+	}
+
+	family, ok := families[g.metric]
+	if !ok {
+		return 0, fmt.Errorf("metric '%s' not found", g.metric)
+	}
+
+	var sum float64
+	for _, metric := range family.GetMetric() {
+		value, err := prometheusMetricValue(family.GetType(), metric)
+		if err != nil {
+			return 0, fmt.Errorf("metric '%s': %v", g.metric, err)
+		}
+		sum += value
+	}
+
+	return sum, nil
+}
+
+// prometheusMetricValue returns the value of a parsed Prometheus metric.
+func prometheusMetricValue(metricType dto.MetricType, metric *dto.Metric) (float64, error) {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return metric.GetCounter().GetValue(), nil
+	case dto.MetricType_GAUGE:
+		return metric.GetGauge().GetValue(), nil
+	case dto.MetricType_UNTYPED:
+		return metric.GetUntyped().GetValue(), nil
+	default:
+		return 0, fmt.Errorf("unsupported metric type %s", metricType)
+	}
+}
@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusClientConfig defines how the Prometheus collectors connect and
+// authenticate to the Prometheus server.
+type PrometheusClientConfig struct {
+	// CAFile is a CA bundle used to verify the server certificate.
+	CAFile string
+	// CertFile and KeyFile is a client certificate and key used for TLS
+	// client authentication.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of the server certificate.
+	InsecureSkipVerify bool
+	// BearerTokenFile is a file containing a bearer token which is sent
+	// with every request. The file is read again when it changes, to
+	// support token rotation.
+	BearerTokenFile string
+	// Username and PasswordFile are used for basic auth.
+	Username     string
+	PasswordFile string
+	// Headers are static headers sent with every request.
+	Headers map[string]string
+}
+
+// RoundTripper returns a http.RoundTripper which applies the TLS and
+// authentication settings of the client config.
+func (c *PrometheusClientConfig) RoundTripper() (http.RoundTripper, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var rt http.RoundTripper = &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	if c.BearerTokenFile != "" && c.Username != "" {
+		return nil, fmt.Errorf("bearer token and basic auth are mutually exclusive")
+	}
+
+	if c.BearerTokenFile != "" {
+		rt = &authRoundTripper{
+			next: rt,
+			secret: &fileContent{
+				path: c.BearerTokenFile,
+			},
+			setAuth: func(req *http.Request, token string) {
+				req.Header.Set("Authorization", "Bearer "+token)
+			},
+		}
+	}
+
+	if c.Username != "" {
+		if c.PasswordFile == "" {
+			return nil, fmt.Errorf("no password file specified for basic auth user %s", c.Username)
+		}
+
+		username := c.Username
+		rt = &authRoundTripper{
+			next: rt,
+			secret: &fileContent{
+				path: c.PasswordFile,
+			},
+			setAuth: func(req *http.Request, password string) {
+				req.SetBasicAuth(username, password)
+			},
+		}
+	}
+
+	if len(c.Headers) > 0 {
+		rt = &headersRoundTripper{
+			next:    rt,
+			headers: c.Headers,
+		}
+	}
+
+	return rt, nil
+}
+
+// tlsConfig returns the TLS config for the client config. It returns nil if
+// no TLS settings are defined.
+func (c *PrometheusClientConfig) tlsConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	return newTLSConfig(c.CAFile, c.CertFile, c.KeyFile, c.InsecureSkipVerify)
+}
+
+// fileContent caches the content of a file and reads it again when the
+// modification time of the file changes.
+type fileContent struct {
+	path    string
+	content string
+	modTime time.Time
+	sync.Mutex
+}
+
+// Get returns the current content of the file with surrounding whitespace
+// removed.
+func (f *fileContent) Get() (string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.ModTime().Equal(f.modTime) {
+		data, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			return "", err
+		}
+		f.content = strings.TrimSpace(string(data))
+		f.modTime = info.ModTime()
+	}
+
+	return f.content, nil
+}
+
+// authRoundTripper sets authentication on requests based on a secret read
+// from a file.
+type authRoundTripper struct {
+	next    http.RoundTripper
+	secret  *fileContent
+	setAuth func(req *http.Request, secret string)
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	secret, err := rt.secret.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials from %s: %v", rt.secret.path, err)
+	}
+
+	req = cloneRequest(req)
+	rt.setAuth(req, secret)
+	return rt.next.RoundTrip(req)
+}
+
+// headersRoundTripper sets static headers on requests.
+type headersRoundTripper struct {
+	next    http.RoundTripper
+	headers map[string]string
+}
+
+func (rt *headersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	for k, v := range rt.headers {
+		req.Header.Set(k, v)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// cloneRequest returns a shallow copy of the request with a deep copy of the
+// headers. A RoundTripper must not modify the original request.
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return r
+}
@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	queryQueryConfKey             = "query"
+	queryServerConfKey            = "server"
+	queryCredentialsSecretConfKey = "credentials-secret"
+	queryNameLabelKey             = "query-name"
+)
+
+// queryBackend runs queries against a time series or search backend.
+type queryBackend interface {
+	// Query runs the query and returns the points of each series of the
+	// result. NaN and null points are omitted.
+	Query(query string) ([][]float64, error)
+}
+
+// queryBackendFactory initializes a queryBackend for a server. credentials
+// is the data of the Secret defined by the credentials-secret annotation, if
+// any.
+type queryBackendFactory func(server string, credentials map[string]string, config map[string]string) (queryBackend, error)
+
+// QueryCollectorPlugin is a collector plugin for initializing collectors for
+// getting Object and External metrics from a query backend configured like
+// the Prometheus collector.
+type QueryCollectorPlugin struct {
+	client         kubernetes.Interface
+	backendName    string
+	defaultServer  string
+	allowedHosts   allowedHosts
+	newBackend     queryBackendFactory
+	externalMetric string
+}
+
+// newQueryCollectorPlugin initializes a new QueryCollectorPlugin. Servers
+// defined by HPAs must be on one of the allowed hosts.
+func newQueryCollectorPlugin(client kubernetes.Interface, backendName, externalMetric, defaultServer string, allowed []string, newBackend queryBackendFactory) *QueryCollectorPlugin {
+	return &QueryCollectorPlugin{
+		client:         client,
+		backendName:    backendName,
+		defaultServer:  defaultServer,
+		allowedHosts:   allowedHosts(allowed),
+		newBackend:     newBackend,
+		externalMetric: externalMetric,
+	}
+}
+
+// NewCollector initializes a new query collector from the specified HPA.
+func (p *QueryCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Type {
+	case autoscalingv2beta1.ObjectMetricSourceType:
+	case autoscalingv2beta1.ExternalMetricSourceType:
+		if config.Name != p.externalMetric {
+			return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+		}
+	default:
+		return nil, fmt.Errorf("%s collector only supports Object and External metrics", p.backendName)
+	}
+
+	server := p.defaultServer
+	if v, ok := config.Config[queryServerConfKey]; ok && v != p.defaultServer {
+		err := p.allowedHosts.check(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s server not allowed: %v", p.backendName, err)
+		}
+		server = v
+	}
+
+	if server == "" {
+		return nil, fmt.Errorf("no %s server specified", p.backendName)
+	}
+
+	var credentials map[string]string
+	if secretName, ok := config.Config[queryCredentialsSecretConfKey]; ok {
+		var err error
+		credentials, err = getSecretData(p.client, hpa.Namespace, secretName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backend, err := p.newBackend(server, credentials, config.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewQueryCollector(p.client, p.backendName, backend, hpa, config, interval)
+	if err != nil {
+		return nil, err
+	}
+	c.server = server
+	return c, nil
+}
+
+// QueryCollector is a collector for getting a metric by running a query
+// against a query backend. The points of each series are reduced with the
+// range aggregate function and the series are reduced to a single value with
+// the result reducer.
+type QueryCollector struct {
+	client          kubernetes.Interface
+	backendName     string
+	backend         queryBackend
+	server          string
+	query           string
+	metricName      string
+	metricType      autoscalingv2beta1.MetricSourceType
+	objectReference custom_metrics.ObjectReference
+	interval        time.Duration
+	perReplica      bool
+	hpa             *autoscalingv2beta1.HorizontalPodAutoscaler
+	labels          map[string]string
+	reducer         *resultReducer
+	rangeAggregate  aggregateFunc
+}
+
+// NewQueryCollector initializes a new QueryCollector. External metrics get
+// the query from the annotation named after the query-name label, other
+// metrics from the query annotation.
+func NewQueryCollector(client kubernetes.Interface, backendName string, backend queryBackend, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*QueryCollector, error) {
+	c := &QueryCollector{
+		client:          client,
+		backendName:     backendName,
+		backend:         backend,
+		objectReference: config.ObjectReference,
+		metricName:      config.Name,
+		metricType:      config.Type,
+		interval:        interval,
+		perReplica:      config.PerReplica,
+		hpa:             hpa,
+		labels:          config.Labels,
+	}
+
+	var query string
+	var data interface{}
+	if c.metricType == autoscalingv2beta1.ExternalMetricSourceType {
+		queryName, ok := config.Labels[queryNameLabelKey]
+		if !ok {
+			return nil, fmt.Errorf("no %s label defined for metric %s", queryNameLabelKey, config.Name)
+		}
+
+		query, ok = config.Config[queryName]
+		if !ok {
+			return nil, fmt.Errorf("no %s query defined for query name '%s'", backendName, queryName)
+		}
+
+		data = config.Labels
+	} else {
+		var ok bool
+		query, ok = config.Config[queryQueryConfKey]
+		if !ok {
+			return nil, fmt.Errorf("no %s query defined", backendName)
+		}
+
+		data = newQueryTemplateData(client, hpa, config)
+	}
+
+	query, err := renderQuery(query, data)
+	if err != nil {
+		return nil, err
+	}
+	c.query = query
+
+	c.reducer, err = newResultReducer(config.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.rangeAggregate = lastValue
+	if v, ok := config.Config[rangeAggregateConfKey]; ok {
+		c.rangeAggregate, err = parseAggregateFunc(v, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// GetMetrics runs the query and returns the reduced result.
+func (c *QueryCollector) GetMetrics() ([]CollectedMetric, error) {
+	series, err := c.backend.Query(c.query)
+	if err != nil {
+		wrapped := fmt.Errorf("failed to run %s query '%s': %v", c.backendName, c.query, err)
+		if _, ok := err.(*BackendError); ok {
+			return nil, backendError(wrapped)
+		}
+		return nil, wrapped
+	}
+
+	values := make([]float64, 0, len(series))
+	for _, points := range series {
+		if len(points) == 0 {
+			continue
+		}
+		values = append(values, c.rangeAggregate(points))
+	}
+
+	value, err := c.reducer.Reduce(values)
+	if err != nil {
+		return nil, fmt.Errorf("%s query '%s' returned no usable values: %v", c.backendName, c.query, err)
+	}
+
+	if c.perReplica {
+		replicas, err := targetRefReplicas(c.client, c.hpa)
+		if err != nil {
+			return nil, err
+		}
+
+		if replicas < 1 {
+			return nil, fmt.Errorf("unable to get average value for %d replicas", replicas)
+		}
+
+		value = value / float64(replicas)
+	}
+
+	var metricValue CollectedMetric
+	switch c.metricType {
+	case autoscalingv2beta1.ObjectMetricSourceType:
+		metricValue = CollectedMetric{
+			Type: c.metricType,
+			Custom: custom_metrics.MetricValue{
+				DescribedObject: c.objectReference,
+				MetricName:      c.metricName,
+				Timestamp:       metav1.Time{Time: time.Now().UTC()},
+				Value:           *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+			},
+		}
+	case autoscalingv2beta1.ExternalMetricSourceType:
+		metricValue = CollectedMetric{
+			Type: c.metricType,
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   c.metricName,
+				MetricLabels: c.labels,
+				Timestamp:    metav1.Time{Time: time.Now().UTC()},
+				Value:        *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+			},
+		}
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *QueryCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the server queried by the collector.
+func (c *QueryCollector) Backend() string {
+	return c.backendName + ":" + c.server
+}
+
+// queryTransport is the transport shared by all query backends, such that
+// connections to the same server are reused by the collectors.
+var queryTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// queryHTTPClient is an HTTP client shared by the query backends.
+type queryHTTPClient struct {
+	client      *http.Client
+	credentials map[string]string
+}
+
+func newQueryHTTPClient(credentials map[string]string) *queryHTTPClient {
+	return &queryHTTPClient{
+		client: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: queryTransport,
+		},
+		credentials: credentials,
+	}
+}
+
+// do sends a request and returns the response body. Basic auth is used if
+// the credentials define a username. Other authorization schemes can be set
+// with header.
+func (c *queryHTTPClient) do(method, url, contentType string, body io.Reader, header http.Header) ([]byte, error) {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	if username, ok := c.credentials["username"]; ok {
+		request.SetBasicAuth(username, c.credentials["password"])
+	}
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, backendError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpStatusError(url, resp, truncate(string(data), 200))
+	}
+
+	return data, nil
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
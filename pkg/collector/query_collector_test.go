@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+// testQueryBackend returns the same series for every query.
+type testQueryBackend struct {
+	series [][]float64
+}
+
+func (b *testQueryBackend) Query(query string) ([][]float64, error) {
+	return b.series, nil
+}
+
+func TestQueryCollectorPerReplica(t *testing.T) {
+	hpa := &autoscalingv2beta1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: autoscalingv2beta1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta1.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       "app",
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		replicas int32
+		value    int64
+		err      bool
+	}{
+		{name: "ready replicas", replicas: 4, value: 25},
+		{name: "no ready replicas", replicas: 0, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Status:     appsv1.DeploymentStatus{ReadyReplicas: tc.replicas},
+			})
+
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Type: autoscalingv2beta1.ObjectMetricSourceType,
+					Name: "backlog",
+				},
+				ObjectReference: custom_metrics.ObjectReference{
+					APIVersion: "v1",
+					Kind:       "Service",
+					Name:       "app-scheduler",
+					Namespace:  "default",
+				},
+				PerReplica: true,
+				Config:     map[string]string{queryQueryConfKey: "backlog"},
+			}
+
+			c, err := NewQueryCollector(client, "test", &testQueryBackend{series: [][]float64{{100}}}, hpa, config, time.Minute)
+			if err != nil {
+				t.Fatalf("failed to create collector: %v", err)
+			}
+
+			metrics, err := c.GetMetrics()
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected error, got %v", metrics)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to get metrics: %v", err)
+			}
+			if v := metrics[0].Custom.Value.Value(); v != tc.value {
+				t.Errorf("expected value %d, got %d", tc.value, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	RabbitMQMessagesReadyMetric          = "rabbitmq-messages-ready"
+	RabbitMQMessagesUnacknowledgedMetric = "rabbitmq-messages-unacknowledged"
+	RabbitMQPublishRateMetric            = "rabbitmq-publish-rate"
+	RabbitMQDeliverRateMetric            = "rabbitmq-deliver-rate"
+	rabbitMQQueueLabelKey                = "queue"
+	rabbitMQVhostLabelKey                = "vhost"
+	rabbitMQURLConfKey                   = "url"
+	rabbitMQCredentialsSecretConfKey     = "credentials-secret"
+	defaultRabbitMQVhost                 = "/"
+)
+
+// RabbitMQMetrics are the external metrics supported by the RabbitMQ
+// collector.
+var RabbitMQMetrics = []string{
+	RabbitMQMessagesReadyMetric,
+	RabbitMQMessagesUnacknowledgedMetric,
+	RabbitMQPublishRateMetric,
+	RabbitMQDeliverRateMetric,
+}
+
+// RabbitMQCollectorPlugin is a collector plugin for initializing collectors
+// for getting RabbitMQ queue metrics via the management HTTP API.
+type RabbitMQCollectorPlugin struct {
+	client     kubernetes.Interface
+	defaultURL string
+}
+
+// NewRabbitMQCollectorPlugin initializes a new RabbitMQCollectorPlugin.
+func NewRabbitMQCollectorPlugin(client kubernetes.Interface, defaultURL string) *RabbitMQCollectorPlugin {
+	return &RabbitMQCollectorPlugin{
+		client:     client,
+		defaultURL: defaultURL,
+	}
+}
+
+// NewCollector initializes a new RabbitMQ collector from the specified HPA.
+func (p *RabbitMQCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Name {
+	case RabbitMQMessagesReadyMetric, RabbitMQMessagesUnacknowledgedMetric, RabbitMQPublishRateMetric, RabbitMQDeliverRateMetric:
+		return NewRabbitMQQueueCollector(p.client, p.defaultURL, hpa, config, interval)
+	}
+
+	return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+}
+
+// rabbitMQQueue is the queue information returned by the RabbitMQ management
+// API.
+type rabbitMQQueue struct {
+	MessagesReady          int64 `json:"messages_ready"`
+	MessagesUnacknowledged int64 `json:"messages_unacknowledged"`
+	MessageStats           struct {
+		PublishDetails    rabbitMQRate `json:"publish_details"`
+		DeliverGetDetails rabbitMQRate `json:"deliver_get_details"`
+	} `json:"message_stats"`
+}
+
+type rabbitMQRate struct {
+	Rate float64 `json:"rate"`
+}
+
+// RabbitMQQueueCollector is a collector for getting the depth or message
+// rates of a RabbitMQ queue.
+type RabbitMQQueueCollector struct {
+	httpClient  *http.Client
+	server      string
+	queueURL    string
+	credentials map[string]string
+	interval    time.Duration
+	labels      map[string]string
+	metricName  string
+	metricType  autoscalingv2beta1.MetricSourceType
+}
+
+// NewRabbitMQQueueCollector initializes a new RabbitMQQueueCollector. The
+// credentials are read from the secret once and the queue is looked up to
+// verify that it exists and the credentials are valid.
+func NewRabbitMQQueueCollector(client kubernetes.Interface, defaultURL string, hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*RabbitMQQueueCollector, error) {
+	queue, ok := config.Labels[rabbitMQQueueLabelKey]
+	if !ok {
+		return nil, fmt.Errorf("rabbitmq queue not specified on metric")
+	}
+
+	vhost := defaultRabbitMQVhost
+	if v, ok := config.Labels[rabbitMQVhostLabelKey]; ok {
+		vhost = v
+	}
+
+	managementURL := defaultURL
+	if v, ok := config.Config[rabbitMQURLConfKey]; ok {
+		managementURL = v
+	}
+
+	if managementURL == "" {
+		return nil, fmt.Errorf("no rabbitmq management url specified")
+	}
+
+	var credentials map[string]string
+	if secretName, ok := config.Config[rabbitMQCredentialsSecretConfKey]; ok {
+		var err error
+		credentials, err = getSecretData(client, hpa.Namespace, secretName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &RabbitMQQueueCollector{
+		httpClient: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: &http.Transport{},
+		},
+		server:      managementURL,
+		queueURL:    fmt.Sprintf("%s/api/queues/%s/%s", strings.TrimSuffix(managementURL, "/"), url.PathEscape(vhost), url.PathEscape(queue)),
+		credentials: credentials,
+		interval:    interval,
+		labels:      config.Labels,
+		metricName:  config.Name,
+		metricType:  config.Type,
+	}
+
+	_, err := c.getQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetMetrics gets the queue metric from the RabbitMQ management API.
+func (c *RabbitMQQueueCollector) GetMetrics() ([]CollectedMetric, error) {
+	queue, err := c.getQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	var value *resource.Quantity
+	switch c.metricName {
+	case RabbitMQMessagesReadyMetric:
+		value = resource.NewQuantity(queue.MessagesReady, resource.DecimalSI)
+	case RabbitMQMessagesUnacknowledgedMetric:
+		value = resource.NewQuantity(queue.MessagesUnacknowledged, resource.DecimalSI)
+	case RabbitMQPublishRateMetric:
+		value = resource.NewMilliQuantity(int64(queue.MessageStats.PublishDetails.Rate*1000), resource.DecimalSI)
+	case RabbitMQDeliverRateMetric:
+		value = resource.NewMilliQuantity(int64(queue.MessageStats.DeliverGetDetails.Rate*1000), resource.DecimalSI)
+	default:
+		return nil, fmt.Errorf("metric '%s' not supported", c.metricName)
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   c.metricName,
+			MetricLabels: c.labels,
+			Timestamp:    metav1.Time{Time: time.Now().UTC()},
+			Value:        *value,
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// getQueue gets the queue information from the management API.
+func (c *RabbitMQQueueCollector) getQueue() (*rabbitMQQueue, error) {
+	request, err := http.NewRequest(http.MethodGet, c.queueURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.credentials != nil {
+		request.SetBasicAuth(c.credentials["username"], c.credentials["password"])
+	}
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, backendError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("rabbitmq queue %s not found", c.queueURL)
+	default:
+		return nil, httpStatusError(c.queueURL, resp, "")
+	}
+
+	var queue rabbitMQQueue
+	err = json.Unmarshal(data, &queue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rabbitmq queue response: %v", err)
+	}
+
+	return &queue, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *RabbitMQQueueCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the RabbitMQ management API of the collector.
+func (c *RabbitMQQueueCollector) Backend() string {
+	return "rabbitmq:" + c.server
+}
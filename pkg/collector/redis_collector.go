@@ -0,0 +1,248 @@
+package collector
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	RedisListLengthMetric         = "redis-list-length"
+	RedisSortedSetLengthMetric    = "redis-sorted-set-length"
+	RedisStreamPendingMetric      = "redis-stream-pending"
+	redisKeyLabelKey              = "key"
+	redisConsumerGroupLabelKey    = "consumer-group"
+	redisKeyConfKey               = "key"
+	redisAddressConfKey           = "address"
+	redisDBConfKey                = "db"
+	redisPasswordSecretConfKey    = "password-secret"
+	redisPasswordSecretKeyConfKey = "password-secret-key"
+	defaultRedisPasswordSecretKey = "password"
+)
+
+// RedisMetrics are the external metrics supported by the Redis collector.
+var RedisMetrics = []string{
+	RedisListLengthMetric,
+	RedisSortedSetLengthMetric,
+	RedisStreamPendingMetric,
+}
+
+// redisClientKey identifies a Redis client. Clients are shared by all
+// collectors connecting to the same database with the same password.
+type redisClientKey struct {
+	address  string
+	db       int
+	password string
+}
+
+// RedisCollectorPlugin is a collector plugin for initializing collectors for
+// getting the backlog of Redis based job queues.
+type RedisCollectorPlugin struct {
+	client         kubernetes.Interface
+	defaultAddress string
+	clients        map[redisClientKey]*redis.Client
+	sync.Mutex
+}
+
+// NewRedisCollectorPlugin initializes a new RedisCollectorPlugin.
+func NewRedisCollectorPlugin(client kubernetes.Interface, defaultAddress string) *RedisCollectorPlugin {
+	return &RedisCollectorPlugin{
+		client:         client,
+		defaultAddress: defaultAddress,
+		clients:        map[redisClientKey]*redis.Client{},
+	}
+}
+
+// NewCollector initializes a new Redis collector from the specified HPA.
+func (p *RedisCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Name {
+	case RedisListLengthMetric, RedisSortedSetLengthMetric, RedisStreamPendingMetric:
+		redisClient, err := p.redisClient(hpa.Namespace, config.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRedisCollector(redisClient, config, interval)
+	}
+
+	return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+}
+
+// redisClient returns a Redis client for the address, database and password
+// defined in the metric config.
+func (p *RedisCollectorPlugin) redisClient(namespace string, config map[string]string) (*redis.Client, error) {
+	key := redisClientKey{
+		address: p.defaultAddress,
+	}
+
+	if v, ok := config[redisAddressConfKey]; ok {
+		key.address = v
+	}
+
+	if key.address == "" {
+		return nil, fmt.Errorf("no redis address specified")
+	}
+
+	if v, ok := config[redisDBConfKey]; ok {
+		db, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis db '%s': %v", v, err)
+		}
+		key.db = db
+	}
+
+	if secretName, ok := config[redisPasswordSecretConfKey]; ok {
+		secretKey := defaultRedisPasswordSecretKey
+		if v, ok := config[redisPasswordSecretKeyConfKey]; ok {
+			secretKey = v
+		}
+
+		password, err := getSecretValue(p.client, namespace, secretName, secretKey)
+		if err != nil {
+			return nil, err
+		}
+		key.password = password
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if client, ok := p.clients[key]; ok {
+		return client, nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     key.address,
+		DB:       key.db,
+		Password: key.password,
+	})
+	p.clients[key] = client
+
+	return client, nil
+}
+
+// RedisCollector is a collector for getting the length of a Redis list or
+// sorted set, or the number of pending messages of a stream consumer group.
+type RedisCollector struct {
+	client        *redis.Client
+	key           string
+	consumerGroup string
+	interval      time.Duration
+	labels        map[string]string
+	metricName    string
+	metricType    autoscalingv2beta1.MetricSourceType
+}
+
+// NewRedisCollector initializes a new RedisCollector. The collector is
+// verified by getting the metric once.
+func NewRedisCollector(client *redis.Client, config *MetricConfig, interval time.Duration) (*RedisCollector, error) {
+	// the key can also be defined as an annotation since label values
+	// can't contain characters like ':' which are common in Redis keys.
+	key, ok := config.Config[redisKeyConfKey]
+	if !ok {
+		key, ok = config.Labels[redisKeyLabelKey]
+		if !ok {
+			return nil, fmt.Errorf("redis key not specified on metric")
+		}
+	}
+
+	c := &RedisCollector{
+		client:     client,
+		key:        key,
+		interval:   interval,
+		labels:     config.Labels,
+		metricName: config.Name,
+		metricType: config.Type,
+	}
+
+	if config.Name == RedisStreamPendingMetric {
+		consumerGroup, ok := config.Labels[redisConsumerGroupLabelKey]
+		if !ok {
+			return nil, fmt.Errorf("redis stream consumer group not specified on metric")
+		}
+		c.consumerGroup = consumerGroup
+	}
+
+	_, err := c.length()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetMetrics gets the length of the Redis key.
+func (c *RedisCollector) GetMetrics() ([]CollectedMetric, error) {
+	length, err := c.length()
+	if err != nil {
+		return nil, err
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   c.metricName,
+			MetricLabels: c.labels,
+			Timestamp:    metav1.Time{Time: time.Now().UTC()},
+			Value:        *resource.NewQuantity(length, resource.DecimalSI),
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// length returns the length of the key depending on the metric.
+func (c *RedisCollector) length() (int64, error) {
+	switch c.metricName {
+	case RedisListLengthMetric:
+		length, err := c.client.LLen(c.key).Result()
+		if err != nil {
+			return 0, redisError(err, "failed to get length of list '%s'", c.key)
+		}
+		return length, nil
+	case RedisSortedSetLengthMetric:
+		length, err := c.client.ZCard(c.key).Result()
+		if err != nil {
+			return 0, redisError(err, "failed to get length of sorted set '%s'", c.key)
+		}
+		return length, nil
+	case RedisStreamPendingMetric:
+		pending, err := c.client.XPending(c.key, c.consumerGroup).Result()
+		if err != nil {
+			return 0, redisError(err, "failed to get pending messages of consumer group '%s' of stream '%s'", c.consumerGroup, c.key)
+		}
+		return pending.Count, nil
+	}
+
+	return 0, fmt.Errorf("metric '%s' not supported", c.metricName)
+}
+
+// redisError formats the error of a command. Error replies of the server,
+// like WRONGTYPE or NOGROUP, are specific to the collector, all other errors
+// are backend errors. Error replies have the unexported type of redis.Nil.
+func redisError(err error, format string, args ...interface{}) error {
+	wrapped := fmt.Errorf(format+": %v", append(args, err)...)
+	if reflect.TypeOf(err) == reflect.TypeOf(redis.Nil) {
+		return wrapped
+	}
+	return backendError(wrapped)
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *RedisCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the Redis server of the collector.
+func (c *RedisCollector) Backend() string {
+	return "redis:" + c.client.Options().Addr
+}
@@ -0,0 +1,285 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+)
+
+// testRedisServer is a minimal Redis server speaking RESP. Commands are
+// answered with the raw reply defined for the command and its arguments,
+// e.g. "LLEN jobs".
+type testRedisServer struct {
+	listener net.Listener
+	replies  map[string]string
+	conns    []net.Conn
+	sync.Mutex
+}
+
+func newTestRedisServer(t *testing.T, replies map[string]string) *testRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testRedisServer{
+		listener: listener,
+		replies:  replies,
+	}
+	go s.serve()
+	return s
+}
+
+func (s *testRedisServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes all client connections.
+func (s *testRedisServer) Close() {
+	s.listener.Close()
+
+	s.Lock()
+	defer s.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testRedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		s.conns = append(s.conns, conn)
+		s.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(reader)
+		if err != nil {
+			return
+		}
+
+		args[0] = strings.ToUpper(args[0])
+		reply, ok := s.replies[strings.Join(args, " ")]
+		if !ok {
+			reply = fmt.Sprintf("-ERR unexpected command '%s'\r\n", strings.Join(args, " "))
+		}
+
+		_, err = io.WriteString(conn, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings.
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	n, err := readRESPLength(reader, '*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		length, err := readRESPLength(reader, '$')
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, length+2)
+		_, err = io.ReadFull(reader, arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:length]))
+	}
+
+	return args, nil
+}
+
+// readRESPLength reads a line like *3 or $5 and returns the number.
+func readRESPLength(reader *bufio.Reader, prefix byte) (int, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) < 2 || line[0] != prefix {
+		return 0, fmt.Errorf("unexpected line '%s'", line)
+	}
+
+	return strconv.Atoi(line[1:])
+}
+
+func newTestRedisMetricConfig(metricName string, labels map[string]string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: autoscalingv2beta1.ExternalMetricSourceType,
+			Name: metricName,
+		},
+		Config: map[string]string{},
+		Labels: labels,
+	}
+}
+
+func TestRedisCollector(t *testing.T) {
+	server := newTestRedisServer(t, map[string]string{
+		"LLEN jobs":                ":42\r\n",
+		"ZCARD delayed-jobs":       ":3\r\n",
+		"XPENDING events workers":  "*4\r\n:7\r\n$3\r\n1-0\r\n$3\r\n7-0\r\n*2\r\n*2\r\n$1\r\na\r\n$1\r\n5\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n",
+		"XPENDING events idle":     "*4\r\n:0\r\n$-1\r\n$-1\r\n*0\r\n",
+		"LLEN queue:high-priority": ":11\r\n",
+	})
+	defer server.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	for _, tc := range []struct {
+		name   string
+		config *MetricConfig
+		length int64
+	}{
+		{
+			name:   "list length",
+			config: newTestRedisMetricConfig(RedisListLengthMetric, map[string]string{redisKeyLabelKey: "jobs"}),
+			length: 42,
+		},
+		{
+			name:   "sorted set length",
+			config: newTestRedisMetricConfig(RedisSortedSetLengthMetric, map[string]string{redisKeyLabelKey: "delayed-jobs"}),
+			length: 3,
+		},
+		{
+			name: "stream pending",
+			config: newTestRedisMetricConfig(RedisStreamPendingMetric, map[string]string{
+				redisKeyLabelKey:           "events",
+				redisConsumerGroupLabelKey: "workers",
+			}),
+			length: 7,
+		},
+		{
+			name: "stream without pending messages",
+			config: newTestRedisMetricConfig(RedisStreamPendingMetric, map[string]string{
+				redisKeyLabelKey:           "events",
+				redisConsumerGroupLabelKey: "idle",
+			}),
+			length: 0,
+		},
+		{
+			name: "key annotation",
+			config: func() *MetricConfig {
+				config := newTestRedisMetricConfig(RedisListLengthMetric, map[string]string{redisKeyLabelKey: "jobs"})
+				config.Config[redisKeyConfKey] = "queue:high-priority"
+				return config
+			}(),
+			length: 11,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewRedisCollector(client, tc.config, time.Minute)
+			if err != nil {
+				t.Fatalf("failed to create collector: %v", err)
+			}
+
+			metrics, err := c.GetMetrics()
+			if err != nil {
+				t.Fatalf("failed to get metrics: %v", err)
+			}
+			if len(metrics) != 1 {
+				t.Fatalf("expected 1 metric, got %d", len(metrics))
+			}
+			if v := metrics[0].External.Value.Value(); v != tc.length {
+				t.Errorf("expected length %d, got %d", tc.length, v)
+			}
+			if metrics[0].External.MetricName != tc.config.Name {
+				t.Errorf("expected metric %s, got %s", tc.config.Name, metrics[0].External.MetricName)
+			}
+		})
+	}
+}
+
+func TestRedisCollectorErrors(t *testing.T) {
+	server := newTestRedisServer(t, map[string]string{
+		"LLEN jobs":               ":42\r\n",
+		"LLEN settings":           "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		"XPENDING events missing": "-NOGROUP No such key 'events' or consumer group 'missing'\r\n",
+	})
+	defer server.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	for _, tc := range []struct {
+		name   string
+		config *MetricConfig
+	}{
+		{
+			name:   "no key",
+			config: newTestRedisMetricConfig(RedisListLengthMetric, map[string]string{}),
+		},
+		{
+			name:   "no consumer group",
+			config: newTestRedisMetricConfig(RedisStreamPendingMetric, map[string]string{redisKeyLabelKey: "events"}),
+		},
+		{
+			name:   "wrong type",
+			config: newTestRedisMetricConfig(RedisListLengthMetric, map[string]string{redisKeyLabelKey: "settings"}),
+		},
+		{
+			name: "unknown consumer group",
+			config: newTestRedisMetricConfig(RedisStreamPendingMetric, map[string]string{
+				redisKeyLabelKey:           "events",
+				redisConsumerGroupLabelKey: "missing",
+			}),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRedisCollector(client, tc.config, time.Minute)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if _, ok := err.(*BackendError); ok {
+				t.Errorf("expected error of the collector, got backend error: %v", err)
+			}
+		})
+	}
+
+	t.Run("server unavailable", func(t *testing.T) {
+		c, err := NewRedisCollector(client, newTestRedisMetricConfig(RedisListLengthMetric, map[string]string{redisKeyLabelKey: "jobs"}), time.Minute)
+		if err != nil {
+			t.Fatalf("failed to create collector: %v", err)
+		}
+
+		server.Close()
+
+		_, err = c.GetMetrics()
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if _, ok := err.(*BackendError); !ok {
+			t.Errorf("expected backend error, got %T: %v", err, err)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SecretAccessAnnotation must be set to "true" on Secrets which collectors
+// are allowed to read. Secrets are referenced by annotations of HPAs, so
+// without it anyone allowed to create HPAs could make the adapter send any
+// Secret of the namespace to a server of their choice.
+const SecretAccessAnnotation = "kube-metrics-adapter/allow-collectors"
+
+// getSecretData returns the data of a Secret. The Secret must allow access
+// by collectors with the SecretAccessAnnotation.
+func getSecretData(client kubernetes.Interface, namespace, name string) (map[string]string, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", namespace, name, err)
+	}
+
+	if secret.Annotations[SecretAccessAnnotation] != "true" {
+		return nil, fmt.Errorf("secret %s/%s can't be used by collectors, it must be annotated with %s: \"true\"", namespace, name, SecretAccessAnnotation)
+	}
+
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	return data, nil
+}
+
+// getSecretValue returns the value of a key of a Secret.
+func getSecretValue(client kubernetes.Interface, namespace, name, key string) (string, error) {
+	data, err := getSecretData(client, namespace, name)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key '%s' not found in secret %s/%s", key, namespace, name)
+	}
+	return value, nil
+}
@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	serviceEndpointsFormatConfKey  = "format"
+	serviceEndpointsTimeoutConfKey = "timeout"
+	serviceEndpointsOnErrorConfKey = "on-error"
+	defaultServiceEndpointsFormat  = "json-path"
+	defaultServiceEndpointsTimeout = 5 * time.Second
+	serviceEndpointsOnErrorDrop    = "drop"
+	serviceEndpointsOnErrorFail    = "fail"
+	// serviceEndpointsConcurrency is the maximum number of endpoints of a
+	// Service scraped at the same time.
+	serviceEndpointsConcurrency = 10
+)
+
+// NewServiceEndpointsCollectorPlugin initializes a new ObjectCollectorPlugin
+// for getting Service metrics with the ServiceEndpointsMetricsGetter.
+func NewServiceEndpointsCollectorPlugin(client kubernetes.Interface) *ObjectCollectorPlugin {
+	return NewObjectCollectorPlugin(client, func(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig) (ObjectMetricsGetter, error) {
+		return NewServiceEndpointsMetricsGetter(client, config.Config)
+	})
+}
+
+// ServiceEndpointsMetricsGetter is a metrics getter which gets the metric for
+// a Service by scraping all ready endpoints of the Service with a pod metrics
+// getter and aggregating the values.
+type ServiceEndpointsMetricsGetter struct {
+	client    kubernetes.Interface
+	getter    PodMetricsGetter
+	aggregate aggregateFunc
+	timeout   time.Duration
+	// failOnError fails the collection if any endpoint can't be scraped
+	// instead of dropping the endpoint.
+	failOnError bool
+}
+
+// NewServiceEndpointsMetricsGetter initializes a new
+// ServiceEndpointsMetricsGetter. The format of the endpoints metrics is
+// defined by the format config key and defaults to json-path. Values are
+// summed unless a different aggregate function is configured. Endpoints
+// which can't be scraped within the timeout are dropped unless on-error is
+// fail.
+func NewServiceEndpointsMetricsGetter(client kubernetes.Interface, config map[string]string) (*ServiceEndpointsMetricsGetter, error) {
+	format := defaultServiceEndpointsFormat
+	if v, ok := config[serviceEndpointsFormatConfKey]; ok {
+		format = v
+	}
+
+	getter, err := newPodMetricsGetter(format, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var aggregate aggregateFunc = sumValues
+	if v, ok := config[aggregateConfKey]; ok {
+		aggregate, err = parseAggregateFunc(v, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := defaultServiceEndpointsTimeout
+	if v, ok := config[serviceEndpointsTimeoutConfKey]; ok {
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse timeout: %v", err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timeout must be positive, got %s", v)
+		}
+	}
+
+	failOnError := false
+	if v, ok := config[serviceEndpointsOnErrorConfKey]; ok {
+		switch v {
+		case serviceEndpointsOnErrorDrop:
+		case serviceEndpointsOnErrorFail:
+			failOnError = true
+		default:
+			return nil, fmt.Errorf("unsupported on-error '%s', must be '%s' or '%s'", v, serviceEndpointsOnErrorDrop, serviceEndpointsOnErrorFail)
+		}
+	}
+
+	return &ServiceEndpointsMetricsGetter{
+		client:      client,
+		getter:      getter,
+		aggregate:   aggregate,
+		timeout:     timeout,
+		failOnError: failOnError,
+	}, nil
+}
+
+// GetObjectMetric gets the metric for the referenced Service by scraping all
+// ready endpoints of the Service in parallel. Endpoints which can't be
+// scraped are dropped, unless the getter fails on errors. The collection
+// fails if no endpoint could be scraped.
+func (g *ServiceEndpointsMetricsGetter) GetObjectMetric(namespace string, reference *autoscalingv2beta1.CrossVersionObjectReference) (float64, error) {
+	if reference.Kind != "Service" {
+		return 0, fmt.Errorf("kind '%s' not supported, only Service is supported", reference.Kind)
+	}
+
+	endpoints, err := g.client.CoreV1().Endpoints(namespace).Get(reference.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	var addresses []v1.EndpointAddress
+	for _, subset := range endpoints.Subsets {
+		addresses = append(addresses, subset.Addresses...)
+	}
+
+	if len(addresses) == 0 {
+		return 0, fmt.Errorf("no ready endpoints of service '%s/%s'", namespace, reference.Name)
+	}
+
+	values := make([]float64, len(addresses))
+	errs := make([]error, len(addresses))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, serviceEndpointsConcurrency)
+	for i, address := range addresses {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, address v1.EndpointAddress) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			values[i], errs[i] = g.scrape(endpointPod(namespace, address))
+		}(i, address)
+	}
+	wg.Wait()
+
+	scraped := make([]float64, 0, len(addresses))
+	var lastErr error
+	for i, address := range addresses {
+		if errs[i] != nil {
+			lastErr = fmt.Errorf("failed to get metrics from endpoint %s of service '%s/%s': %v", address.IP, namespace, reference.Name, errs[i])
+			if g.failOnError {
+				return 0, lastErr
+			}
+			glog.Warningf("Dropping endpoint: %v", lastErr)
+			continue
+		}
+		scraped = append(scraped, values[i])
+	}
+
+	if len(scraped) == 0 {
+		return 0, fmt.Errorf("no endpoint of service '%s/%s' could be scraped: %v", namespace, reference.Name, lastErr)
+	}
+
+	return g.aggregate(scraped), nil
+}
+
+// scrape gets the metric of an endpoint. It gives up after the timeout of the
+// getter, the request itself is bounded by the timeout of the pod metrics
+// getter.
+func (g *ServiceEndpointsMetricsGetter) scrape(pod *v1.Pod) (float64, error) {
+	type result struct {
+		value float64
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := g.getter.GetMetric(pod)
+		done <- result{value: value, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-time.After(g.timeout):
+		return 0, fmt.Errorf("timed out after %s", g.timeout)
+	}
+}
+
+// endpointPod returns a pod representing an endpoint address such that it can
+// be scraped by a PodMetricsGetter.
+func endpointPod(namespace string, address v1.EndpointAddress) *v1.Pod {
+	name := address.IP
+	if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+		name = address.TargetRef.Name
+	}
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Status: v1.PodStatus{
+			PodIP: address.IP,
+		},
+	}
+}
@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// endpointsGetter returns the metric of an endpoint by its IP. Endpoints
+// without a value return an error, slow endpoints block until released.
+type endpointsGetter struct {
+	values map[string]float64
+	slow   map[string]bool
+	block  chan struct{}
+}
+
+func (g *endpointsGetter) GetMetric(pod *v1.Pod) (float64, error) {
+	if g.slow[pod.Status.PodIP] {
+		<-g.block
+	}
+	value, ok := g.values[pod.Status.PodIP]
+	if !ok {
+		return 0, fmt.Errorf("connection refused")
+	}
+	return value, nil
+}
+
+func TestServiceEndpointsGetObjectMetric(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		ips         []string
+		values      map[string]float64
+		slow        map[string]bool
+		failOnError bool
+		expected    float64
+		err         bool
+	}{
+		{
+			name:     "all endpoints",
+			ips:      []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			values:   map[string]float64{"10.0.0.1": 1, "10.0.0.2": 2, "10.0.0.3": 3},
+			expected: 6,
+		},
+		{
+			name:     "failed endpoint is dropped",
+			ips:      []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			values:   map[string]float64{"10.0.0.1": 1, "10.0.0.3": 3},
+			expected: 4,
+		},
+		{
+			name:     "slow endpoint is dropped",
+			ips:      []string{"10.0.0.1", "10.0.0.2"},
+			values:   map[string]float64{"10.0.0.1": 1, "10.0.0.2": 2},
+			slow:     map[string]bool{"10.0.0.2": true},
+			expected: 1,
+		},
+		{
+			name:        "failed endpoint fails the collection",
+			ips:         []string{"10.0.0.1", "10.0.0.2"},
+			values:      map[string]float64{"10.0.0.1": 1},
+			failOnError: true,
+			err:         true,
+		},
+		{
+			name:   "all endpoints failed",
+			ips:    []string{"10.0.0.1", "10.0.0.2"},
+			values: map[string]float64{},
+			err:    true,
+		},
+		{
+			name: "no ready endpoints",
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var addresses []v1.EndpointAddress
+			for _, ip := range tc.ips {
+				addresses = append(addresses, v1.EndpointAddress{IP: ip})
+			}
+
+			client := fake.NewSimpleClientset(&v1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: "queue", Namespace: "default"},
+				Subsets:    []v1.EndpointSubset{{Addresses: addresses}},
+			})
+
+			block := make(chan struct{})
+			defer close(block)
+
+			g := &ServiceEndpointsMetricsGetter{
+				client:      client,
+				getter:      &endpointsGetter{values: tc.values, slow: tc.slow, block: block},
+				aggregate:   sumValues,
+				timeout:     50 * time.Millisecond,
+				failOnError: tc.failOnError,
+			}
+
+			value, err := g.GetObjectMetric("default", &autoscalingv2beta1.CrossVersionObjectReference{Kind: "Service", Name: "queue"})
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected error, got value %f", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != tc.expected {
+				t.Errorf("expected %f, got %f", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestNewServiceEndpointsMetricsGetterConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config map[string]string
+		err    bool
+	}{
+		{name: "defaults", config: map[string]string{"json-key": "$.value", "port": "9090"}},
+		{name: "timeout and on-error", config: map[string]string{"json-key": "$.value", "port": "9090", "timeout": "2s", "on-error": "fail"}},
+		{name: "invalid timeout", config: map[string]string{"json-key": "$.value", "port": "9090", "timeout": "soon"}, err: true},
+		{name: "negative timeout", config: map[string]string{"json-key": "$.value", "port": "9090", "timeout": "-1s"}, err: true},
+		{name: "invalid on-error", config: map[string]string{"json-key": "$.value", "port": "9090", "on-error": "ignore"}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewServiceEndpointsMetricsGetter(fake.NewSimpleClientset(), tc.config)
+			if tc.err != (err != nil) {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
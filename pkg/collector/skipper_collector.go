@@ -144,6 +144,26 @@ func (c *SkipperCollector) Interval() time.Duration {
 	return c.interval
 }
 
+// Backend returns the Prometheus server used for getting the metrics.
+func (c *SkipperCollector) Backend() string {
+	if plugin, ok := c.plugin.(*PrometheusCollectorPlugin); ok {
+		return plugin.backend()
+	}
+	return ""
+}
+
+// Fingerprint identifies the ingress and the scale target the requests are
+// averaged over.
+func (c *SkipperCollector) Fingerprint() string {
+	backend := c.Backend()
+	if backend == "" {
+		return ""
+	}
+
+	return fingerprint("skipper", backend, c.metricName, c.objectReference,
+		c.hpa.Namespace, c.hpa.Spec.ScaleTargetRef, c.interval)
+}
+
 func targetRefReplicas(client kubernetes.Interface, hpa *autoscalingv2beta1.HorizontalPodAutoscaler) (int32, error) {
 	var replicas int32
 	switch hpa.Spec.ScaleTargetRef.Kind {
@@ -0,0 +1,309 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/ghodss/yaml"
+	// register the supported database drivers.
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// SQLQueryMetric is the external metric for SQL queries. The query is
+	// defined in an annotation named after the query-name label of the
+	// metric selector and is run against the datasource defined by the
+	// datasource label.
+	SQLQueryMetric             = "sql-query"
+	sqlQueryNameLabelKey       = "query-name"
+	sqlDatasourceLabelKey      = "datasource"
+	defaultSQLStatementTimeout = 10 * time.Second
+	defaultSQLMaxOpenConns     = 2
+	defaultSQLMaxIdleConns     = 1
+	defaultSQLConnMaxLifetime  = 5 * time.Minute
+)
+
+// SQLDatasource defines a named database which can be queried by HPAs.
+type SQLDatasource struct {
+	// Name is the name used to reference the datasource from an HPA.
+	Name string `json:"name"`
+	// Driver is the database driver, either postgres or mysql.
+	Driver string `json:"driver"`
+	// DSN is the driver specific data source name.
+	DSN string `json:"dsn"`
+	// MaxOpenConns and MaxIdleConns limit the connection pool of the
+	// datasource.
+	MaxOpenConns int `json:"maxOpenConns"`
+	MaxIdleConns int `json:"maxIdleConns"`
+	// StatementTimeout is the maximum duration of a query e.g. 5s.
+	StatementTimeout string `json:"statementTimeout"`
+}
+
+// LoadSQLDatasources loads the datasources from a YAML file in the format:
+//
+//	datasources:
+//	- name: jobs
+//	  driver: postgres
+//	  dsn: postgres://reader@jobs-db/jobs?sslmode=require
+//	  statementTimeout: 5s
+func LoadSQLDatasources(filename string) ([]SQLDatasource, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sql datasources file: %v", err)
+	}
+
+	var config struct {
+		Datasources []SQLDatasource `json:"datasources"`
+	}
+
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sql datasources file: %v", err)
+	}
+
+	return config.Datasources, nil
+}
+
+// sqlDatabase is a connection pool for a datasource.
+type sqlDatabase struct {
+	name             string
+	db               *sql.DB
+	statementTimeout time.Duration
+}
+
+// SQLCollectorPlugin is a collector plugin for initializing collectors for
+// getting metrics from SQL queries. Only queries against the datasources
+// configured at startup are allowed.
+type SQLCollectorPlugin struct {
+	databases map[string]*sqlDatabase
+}
+
+// NewSQLCollectorPlugin initializes a new SQLCollectorPlugin with a
+// connection pool for each datasource.
+func NewSQLCollectorPlugin(datasources []SQLDatasource) (*SQLCollectorPlugin, error) {
+	databases := make(map[string]*sqlDatabase, len(datasources))
+	for _, datasource := range datasources {
+		if datasource.Name == "" {
+			return nil, fmt.Errorf("sql datasource without name")
+		}
+
+		if _, ok := databases[datasource.Name]; ok {
+			return nil, fmt.Errorf("duplicate sql datasource '%s'", datasource.Name)
+		}
+
+		database, err := newSQLDatabase(datasource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sql datasource '%s': %v", datasource.Name, err)
+		}
+		databases[datasource.Name] = database
+	}
+
+	return &SQLCollectorPlugin{
+		databases: databases,
+	}, nil
+}
+
+// newSQLDatabase opens the connection pool for a datasource. Connections are
+// established lazily.
+func newSQLDatabase(datasource SQLDatasource) (*sqlDatabase, error) {
+	statementTimeout := defaultSQLStatementTimeout
+	if datasource.StatementTimeout != "" {
+		d, err := time.ParseDuration(datasource.StatementTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse statement timeout: %v", err)
+		}
+		statementTimeout = d
+	}
+
+	db, err := sql.Open(datasource.Driver, datasource.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	maxOpenConns := defaultSQLMaxOpenConns
+	if datasource.MaxOpenConns > 0 {
+		maxOpenConns = datasource.MaxOpenConns
+	}
+
+	maxIdleConns := defaultSQLMaxIdleConns
+	if datasource.MaxIdleConns > 0 {
+		maxIdleConns = datasource.MaxIdleConns
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(defaultSQLConnMaxLifetime)
+
+	return &sqlDatabase{
+		name:             datasource.Name,
+		db:               db,
+		statementTimeout: statementTimeout,
+	}, nil
+}
+
+// NewCollector initializes a new SQL collector from the specified HPA.
+func (p *SQLCollectorPlugin) NewCollector(hpa *autoscalingv2beta1.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	switch config.Name {
+	case SQLQueryMetric:
+		datasource, ok := config.Labels[sqlDatasourceLabelKey]
+		if !ok {
+			return nil, fmt.Errorf("sql datasource not specified on metric")
+		}
+
+		database, ok := p.databases[datasource]
+		if !ok {
+			return nil, fmt.Errorf("sql datasource '%s' is not configured", datasource)
+		}
+
+		return NewSQLCollector(database, config, interval)
+	}
+
+	return nil, fmt.Errorf("metric '%s' not supported", config.Name)
+}
+
+// SQLCollector is a collector for getting the result of a SQL query.
+type SQLCollector struct {
+	database   *sqlDatabase
+	query      string
+	interval   time.Duration
+	labels     map[string]string
+	metricName string
+	metricType autoscalingv2beta1.MetricSourceType
+}
+
+// NewSQLCollector initializes a new SQLCollector. The query is run once to
+// verify that it returns a single numeric value.
+func NewSQLCollector(database *sqlDatabase, config *MetricConfig, interval time.Duration) (*SQLCollector, error) {
+	queryName, ok := config.Labels[sqlQueryNameLabelKey]
+	if !ok {
+		return nil, fmt.Errorf("sql query name not specified on metric")
+	}
+
+	query, ok := config.Config[queryName]
+	if !ok {
+		return nil, fmt.Errorf("no sql query defined for query name '%s'", queryName)
+	}
+
+	c := &SQLCollector{
+		database:   database,
+		query:      query,
+		interval:   interval,
+		labels:     config.Labels,
+		metricName: config.Name,
+		metricType: config.Type,
+	}
+
+	_, err := c.queryValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetMetrics runs the SQL query and returns the result.
+func (c *SQLCollector) GetMetrics() ([]CollectedMetric, error) {
+	value, err := c.queryValue()
+	if err != nil {
+		return nil, err
+	}
+
+	metricValue := CollectedMetric{
+		Type: c.metricType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   c.metricName,
+			MetricLabels: c.labels,
+			Timestamp:    metav1.Time{Time: time.Now().UTC()},
+			Value:        *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+		},
+	}
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// queryValue runs the query in a read-only transaction and returns the single
+// numeric cell of the result.
+func (c *SQLCollector) queryValue() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.database.statementTimeout)
+	defer cancel()
+
+	tx, err := c.database.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, backendError(fmt.Errorf("failed to begin read-only transaction: %v", err))
+	}
+	// the transaction is read-only, nothing to commit.
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, c.query)
+	if err != nil {
+		return 0, sqlError(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(columns) != 1 {
+		return 0, fmt.Errorf("expected sql query to return 1 column, got %d", len(columns))
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, sqlError(err)
+		}
+		return 0, fmt.Errorf("expected sql query to return 1 row, got none")
+	}
+
+	var value sql.NullFloat64
+	err = rows.Scan(&value)
+	if err != nil {
+		return 0, fmt.Errorf("expected sql query to return a numeric value: %v", err)
+	}
+
+	if !value.Valid {
+		return 0, fmt.Errorf("expected sql query to return a numeric value, got NULL")
+	}
+
+	if rows.Next() {
+		return 0, fmt.Errorf("expected sql query to return 1 row, got more")
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, sqlError(err)
+	}
+
+	return value.Float64, nil
+}
+
+// sqlError returns the error of a failed query. Timeouts and connection
+// errors are backend errors, other errors, like a syntax error, are specific
+// to the collector.
+func sqlError(err error) error {
+	wrapped := fmt.Errorf("failed to run sql query: %v", err)
+	if _, ok := err.(net.Error); ok || err == driver.ErrBadConn || err == context.DeadlineExceeded {
+		return backendError(wrapped)
+	}
+	return wrapped
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *SQLCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Backend returns the datasource of the collector.
+func (c *SQLCollector) Backend() string {
+	return "sql:" + c.database.name
+}
@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// backendRetryDelay is the time a collector waits before trying again if its
+// backend is running the maximum number of collectors.
+const backendRetryDelay = 1 * time.Second
+
+var backendThrottled = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_backend_throttled_total",
+		Help: "Number of collector runs delayed because the concurrency or rate limit of the backend was reached.",
+	},
+	[]string{"backend"},
+)
+
+func init() {
+	prometheus.MustRegister(backendThrottled)
+}
+
+// backendLimiter limits the number of collectors of a backend running at the
+// same time and per second.
+type backendLimiter struct {
+	backend string
+	slots   chan struct{}
+	rate    *rate.Limiter
+}
+
+// acquire reserves a run of a collector. It returns a function releasing the
+// run once the collector is done or, if a limit is reached, nil and the time
+// to wait before trying again.
+func (l *backendLimiter) acquire() (func(), time.Duration) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			backendThrottled.WithLabelValues(l.backend).Inc()
+			return nil, jitter(backendRetryDelay)
+		}
+	}
+
+	if l.rate != nil {
+		reservation := l.rate.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			l.release()
+			backendThrottled.WithLabelValues(l.backend).Inc()
+			return nil, delay
+		}
+	}
+
+	return l.release, 0
+}
+
+func (l *backendLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// backendLimiters holds the limiters of all backends.
+type backendLimiters struct {
+	concurrency int
+	rateLimit   float64
+	limiters    map[string]*backendLimiter
+	sync.Mutex
+}
+
+func newBackendLimiters(concurrency int, rateLimit float64) *backendLimiters {
+	return &backendLimiters{
+		concurrency: concurrency,
+		rateLimit:   rateLimit,
+		limiters:    map[string]*backendLimiter{},
+	}
+}
+
+// Get returns the limiter for the backend of a collector. It returns nil for
+// collectors which don't define a backend or if no limits are configured.
+func (l *backendLimiters) Get(backend string) *backendLimiter {
+	if backend == "" || (l.concurrency <= 0 && l.rateLimit <= 0) {
+		return nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	limiter, ok := l.limiters[backend]
+	if !ok {
+		limiter = &backendLimiter{
+			backend: backend,
+		}
+
+		burst := 1
+		if l.concurrency > 0 {
+			limiter.slots = make(chan struct{}, l.concurrency)
+			burst = l.concurrency
+		}
+
+		if l.rateLimit > 0 {
+			limiter.rate = rate.NewLimiter(rate.Limit(l.rateLimit), burst)
+		}
+
+		l.limiters[backend] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// collectorJitterFactor is the maximum fraction by which the interval
+	// of a collector is randomly increased or decreased such that
+	// collectors created at the same time don't run at the same time.
+	collectorJitterFactor = 0.1
+	// collectorMaxBackoff is the maximum interval of a collector which is
+	// backing off after consecutive failures.
+	collectorMaxBackoff = 5 * time.Minute
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// initialDelay returns a random delay before the first run of a collector.
+func initialDelay(interval time.Duration) time.Duration {
+	return time.Duration(rand.Float64() * collectorJitterFactor * float64(interval))
+}
+
+// nextRun returns the time to wait before the next run of a collector. The
+// interval is doubled for each consecutive failure, up to
+// collectorMaxBackoff, and jittered.
+func nextRun(interval time.Duration, failures int) time.Duration {
+	wait := interval
+	for i := 0; i < failures && wait < collectorMaxBackoff; i++ {
+		wait *= 2
+	}
+
+	// intervals longer than the maximum backoff are kept as is.
+	if wait > collectorMaxBackoff && interval < collectorMaxBackoff {
+		wait = collectorMaxBackoff
+	}
+
+	return jitter(wait)
+}
+
+// jitter randomly increases or decreases d by up to collectorJitterFactor.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((2*rand.Float64()-1)*collectorJitterFactor*float64(d))
+}
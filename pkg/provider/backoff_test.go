@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		interval time.Duration
+		failures int
+		wait     time.Duration
+	}{
+		{name: "no failures", interval: time.Minute, failures: 0, wait: time.Minute},
+		{name: "one failure", interval: time.Minute, failures: 1, wait: 2 * time.Minute},
+		{name: "two failures", interval: time.Minute, failures: 2, wait: 4 * time.Minute},
+		{name: "capped at max backoff", interval: time.Minute, failures: 3, wait: collectorMaxBackoff},
+		{name: "many failures", interval: time.Minute, failures: 100, wait: collectorMaxBackoff},
+		{name: "short interval", interval: 10 * time.Second, failures: 4, wait: 160 * time.Second},
+		{name: "interval longer than max backoff", interval: 10 * time.Minute, failures: 3, wait: 10 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			min := time.Duration(float64(tc.wait) * (1 - collectorJitterFactor))
+			max := time.Duration(float64(tc.wait) * (1 + collectorJitterFactor))
+
+			// the wait is random, so it's checked against the
+			// bounds of the jitter for many runs.
+			for i := 0; i < 1000; i++ {
+				wait := nextRun(tc.interval, tc.failures)
+				if wait < min || wait > max {
+					t.Fatalf("expected wait between %s and %s, got %s", min, max, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestInitialDelay(t *testing.T) {
+	interval := time.Minute
+	max := time.Duration(collectorJitterFactor * float64(interval))
+
+	for i := 0; i < 1000; i++ {
+		delay := initialDelay(interval)
+		if delay < 0 || delay > max {
+			t.Fatalf("expected initial delay between 0 and %s, got %s", max, delay)
+		}
+	}
+}
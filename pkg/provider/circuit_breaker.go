@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type circuitBreakerState int
+
+const (
+	circuitBreakerClosed circuitBreakerState = iota
+	circuitBreakerOpen
+	circuitBreakerHalfOpen
+)
+
+func (s circuitBreakerState) String() string {
+	switch s {
+	case circuitBreakerOpen:
+		return "open"
+	case circuitBreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+var circuitBreakerStateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "kube_metrics_adapter_circuit_breaker_state",
+		Help: "State of the circuit breaker of a collector backend (0 = closed, 1 = open, 2 = half-open).",
+	},
+	[]string{"backend"},
+)
+
+func init() {
+	prometheus.MustRegister(circuitBreakerStateGauge)
+}
+
+// circuitBreaker pauses all collectors of a backend after consecutive
+// failures. Once the timeout has passed a single collector is allowed to try
+// the backend. The breaker closes if it succeeds and opens again otherwise.
+type circuitBreaker struct {
+	backend string
+	// threshold is the number of consecutive failures after which the
+	// breaker opens, timeout the time it stays open before a single
+	// collector is allowed to try the backend again.
+	threshold int
+	timeout   time.Duration
+	// now returns the current time, it's replaced in tests.
+	now      func() time.Time
+	state    circuitBreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+	sync.Mutex
+}
+
+func newCircuitBreaker(backend string, threshold int, timeout time.Duration) *circuitBreaker {
+	b := &circuitBreaker{
+		backend:   backend,
+		threshold: threshold,
+		timeout:   timeout,
+		now:       time.Now,
+	}
+	circuitBreakerStateGauge.WithLabelValues(backend).Set(float64(circuitBreakerClosed))
+	return b
+}
+
+// Allow returns true if a collector may call the backend. If it returns true
+// the result must be reported with Done.
+func (b *circuitBreaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case circuitBreakerOpen:
+		if b.now().Sub(b.openedAt) < b.timeout {
+			return false
+		}
+		b.setState(circuitBreakerHalfOpen)
+		b.trial = true
+		return true
+	case circuitBreakerHalfOpen:
+		// only a single trial call is allowed.
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Done records the result of a call to the backend. Only backend errors are
+// failures, other errors mean that the backend did respond.
+func (b *circuitBreaker) Done(err error) {
+	if _, ok := err.(*collector.BackendError); !ok {
+		err = nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.state == circuitBreakerHalfOpen {
+		b.trial = false
+		if err != nil {
+			b.open()
+			return
+		}
+		b.failures = 0
+		b.setState(circuitBreakerClosed)
+		return
+	}
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitBreakerClosed && b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+// State returns the current state of the circuit breaker.
+func (b *circuitBreaker) State() circuitBreakerState {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(circuitBreakerOpen)
+}
+
+func (b *circuitBreaker) setState(state circuitBreakerState) {
+	if b.state == state && state != circuitBreakerOpen {
+		return
+	}
+
+	switch state {
+	case circuitBreakerOpen:
+		glog.Warningf("Circuit breaker for backend %s opened after %d consecutive failure(s), pausing collectors for %s", b.backend, b.failures, b.timeout)
+	case circuitBreakerHalfOpen:
+		glog.Infof("Circuit breaker for backend %s is half-open, trying backend", b.backend)
+	case circuitBreakerClosed:
+		glog.Infof("Circuit breaker for backend %s closed", b.backend)
+	}
+
+	b.state = state
+	circuitBreakerStateGauge.WithLabelValues(b.backend).Set(float64(state))
+}
+
+// circuitBreakers holds the circuit breakers of all backends.
+type circuitBreakers struct {
+	threshold int
+	timeout   time.Duration
+	breakers  map[string]*circuitBreaker
+	sync.Mutex
+}
+
+// newCircuitBreakers initializes the circuit breakers. A threshold of 0
+// disables them.
+func newCircuitBreakers(threshold int, timeout time.Duration) *circuitBreakers {
+	return &circuitBreakers{
+		threshold: threshold,
+		timeout:   timeout,
+		breakers:  map[string]*circuitBreaker{},
+	}
+}
+
+// Get returns the circuit breaker for the backend of a collector. It returns
+// nil for collectors which don't define a backend or if the circuit breakers
+// are disabled.
+func (b *circuitBreakers) Get(backend string) *circuitBreaker {
+	if backend == "" || b.threshold <= 0 {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	breaker, ok := b.breakers[backend]
+	if !ok {
+		breaker = newCircuitBreaker(backend, b.threshold, b.timeout)
+		b.breakers[backend] = breaker
+	}
+	return breaker
+}
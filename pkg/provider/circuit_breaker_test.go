@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
+)
+
+// breakerStep advances the clock, asks the breaker to allow a call and
+// reports the result of the call, unless it's pending.
+type breakerStep struct {
+	advance time.Duration
+	err     error
+	pending bool
+	allowed bool
+	state   circuitBreakerState
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	backendErr := &collector.BackendError{Err: errors.New("connection refused")}
+	collectorErr := errors.New("invalid query")
+
+	for _, tc := range []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{
+			name: "opens after threshold",
+			steps: []breakerStep{
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerOpen},
+				{allowed: false, state: circuitBreakerOpen},
+			},
+		},
+		{
+			name: "collector errors are no failures",
+			steps: []breakerStep{
+				{err: collectorErr, allowed: true, state: circuitBreakerClosed},
+				{err: collectorErr, allowed: true, state: circuitBreakerClosed},
+				{err: collectorErr, allowed: true, state: circuitBreakerClosed},
+				{allowed: true, state: circuitBreakerClosed},
+			},
+		},
+		{
+			name: "success resets failures",
+			steps: []breakerStep{
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+			},
+		},
+		{
+			name: "half-open closes on success",
+			steps: []breakerStep{
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerOpen},
+				{advance: 59 * time.Second, allowed: false, state: circuitBreakerOpen},
+				{advance: time.Second, allowed: true, state: circuitBreakerClosed},
+				{allowed: true, state: circuitBreakerClosed},
+			},
+		},
+		{
+			name: "half-open opens again on failure",
+			steps: []breakerStep{
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerOpen},
+				{advance: time.Minute, err: backendErr, allowed: true, state: circuitBreakerOpen},
+				{advance: 59 * time.Second, allowed: false, state: circuitBreakerOpen},
+				{advance: time.Second, allowed: true, state: circuitBreakerClosed},
+			},
+		},
+		{
+			name: "half-open allows a single trial",
+			steps: []breakerStep{
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerClosed},
+				{err: backendErr, allowed: true, state: circuitBreakerOpen},
+				{advance: time.Minute, pending: true, allowed: true, state: circuitBreakerHalfOpen},
+				{pending: true, allowed: false, state: circuitBreakerHalfOpen},
+				{advance: time.Hour, pending: true, allowed: false, state: circuitBreakerHalfOpen},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+			breaker := newCircuitBreaker("test:"+tc.name, 3, time.Minute)
+			breaker.now = func() time.Time { return now }
+
+			for i, step := range tc.steps {
+				now = now.Add(step.advance)
+
+				allowed := breaker.Allow()
+				if allowed != step.allowed {
+					t.Fatalf("step %d: expected allowed %t, got %t", i, step.allowed, allowed)
+				}
+				if allowed && !step.pending {
+					breaker.Done(step.err)
+				}
+
+				if state := breaker.State(); state != step.state {
+					t.Fatalf("step %d: expected state %s, got %s", i, step.state, state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakersDisabled(t *testing.T) {
+	if breaker := newCircuitBreakers(0, time.Minute).Get("prometheus:http://prometheus"); breaker != nil {
+		t.Error("expected no circuit breaker with a threshold of 0")
+	}
+
+	breakers := newCircuitBreakers(5, time.Minute)
+	if breakers.Get("") != nil {
+		t.Error("expected no circuit breaker without backend")
+	}
+	if breakers.Get("backend") != breakers.Get("backend") {
+		t.Error("expected the same circuit breaker for the same backend")
+	}
+}
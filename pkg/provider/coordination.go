@@ -0,0 +1,327 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CoordinationModeLeader makes a single elected replica collect all
+	// metrics.
+	CoordinationModeLeader = "leader"
+	// CoordinationModeSharded splits the HPAs between the replicas.
+	CoordinationModeSharded = "sharded"
+
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+
+	// shardMembersInterval is the interval at which the replicas are
+	// looked up in sharded mode.
+	shardMembersInterval = 10 * time.Second
+	// shardVirtualNodes is the number of points of each replica on the
+	// hash ring.
+	shardVirtualNodes = 100
+)
+
+// Coordinator decides which replica of the adapter collects the metrics of an
+// HPA. The other replicas get the metrics from their peers.
+type Coordinator interface {
+	// Run runs the coordination until the context is canceled.
+	Run(ctx context.Context)
+	// Owns returns true if this replica should collect the metrics of the
+	// HPA.
+	Owns(namespace, name string) bool
+	// Peers returns the addresses of the replicas to get metrics from.
+	Peers() []string
+	// Changed is notified when the HPAs owned by this replica change.
+	Changed() <-chan struct{}
+	// Token returns the token the replicas authenticate to each other
+	// with.
+	Token() string
+}
+
+// CoordinationConfig configures the coordination of the adapter replicas.
+type CoordinationConfig struct {
+	// Mode is either CoordinationModeLeader or CoordinationModeSharded.
+	Mode string
+	// Namespace is the namespace of the lock and the service.
+	Namespace string
+	// Name is the name of the Lease used as leader election lock in leader
+	// mode and of the service listing the replicas in sharded mode.
+	Name string
+	// Address is the address, i.e. pod IP and port, other replicas get
+	// the metrics of this replica from.
+	Address string
+	// Token is the token the replicas authenticate to each other with. It's
+	// required, since the peer API serves all collected metrics.
+	Token string
+}
+
+// NewCoordinator initializes a new Coordinator for the configured mode.
+func NewCoordinator(client kubernetes.Interface, config CoordinationConfig) (Coordinator, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("no peer address defined for coordination")
+	}
+
+	if config.Token == "" {
+		return nil, fmt.Errorf("no token defined for coordination")
+	}
+
+	switch config.Mode {
+	case CoordinationModeLeader:
+		return newLeaderCoordinator(client, config)
+	case CoordinationModeSharded:
+		return newShardedCoordinator(client, config)
+	default:
+		return nil, fmt.Errorf("unknown coordination mode '%s'", config.Mode)
+	}
+}
+
+// notifyChanged notifies a changed channel without blocking.
+func notifyChanged(changed chan struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
+
+// leaderCoordinator elects a single replica collecting the metrics of all
+// HPAs. The followers get the metrics from the leader.
+type leaderCoordinator struct {
+	address string
+	token   string
+	elector *leaderElector
+	changed chan struct{}
+}
+
+func newLeaderCoordinator(client kubernetes.Interface, config CoordinationConfig) (*leaderCoordinator, error) {
+	// the identity is the peer address such that the followers know
+	// where to get the metrics from.
+	lock := newLeaseLock(client.Discovery().RESTClient(), config.Namespace, config.Name)
+	return newLeaderCoordinatorWithLock(lock, config), nil
+}
+
+func newLeaderCoordinatorWithLock(lock leaderLock, config CoordinationConfig) *leaderCoordinator {
+	c := &leaderCoordinator{
+		address: config.Address,
+		token:   config.Token,
+		changed: make(chan struct{}, 1),
+	}
+
+	c.elector = &leaderElector{
+		lock:          lock,
+		identity:      config.Address,
+		leaseDuration: leaderElectionLeaseDuration,
+		renewDeadline: leaderElectionRenewDeadline,
+		retryPeriod:   leaderElectionRetryPeriod,
+		onChanged: func(leading bool) {
+			if leading {
+				glog.Infof("Started leading, collecting metrics of all HPAs")
+			} else {
+				glog.Infof("Stopped leading, getting metrics from the leader")
+			}
+			notifyChanged(c.changed)
+		},
+	}
+
+	return c
+}
+
+// Run takes part in the leader election until the context is canceled. A
+// replica which lost the leadership becomes a candidate again. The lock is
+// released when the context is canceled, such that the followers stop
+// getting metrics from this replica.
+func (c *leaderCoordinator) Run(ctx context.Context) {
+	c.elector.Run(ctx)
+	glog.Info("Stopped leader election.")
+}
+
+// Owns returns true if this replica is the leader.
+func (c *leaderCoordinator) Owns(namespace, name string) bool {
+	return c.elector.IsLeader()
+}
+
+// Peers returns the address of the leader unless this replica is the leader.
+func (c *leaderCoordinator) Peers() []string {
+	leader := c.elector.GetLeader()
+	if leader == "" || leader == c.address {
+		return nil
+	}
+	return []string{leader}
+}
+
+func (c *leaderCoordinator) Changed() <-chan struct{} {
+	return c.changed
+}
+
+func (c *leaderCoordinator) Token() string {
+	return c.token
+}
+
+// shardedCoordinator splits the HPAs between the ready replicas listed by the
+// endpoints of the adapter service using consistent hashing.
+type shardedCoordinator struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	address   string
+	port      string
+	token     string
+	ring      *hashRing
+	changed   chan struct{}
+	sync.RWMutex
+}
+
+func newShardedCoordinator(client kubernetes.Interface, config CoordinationConfig) (*shardedCoordinator, error) {
+	_, port, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address '%s': %v", config.Address, err)
+	}
+
+	return &shardedCoordinator{
+		client:    client,
+		namespace: config.Namespace,
+		name:      config.Name,
+		address:   config.Address,
+		port:      port,
+		token:     config.Token,
+		ring:      newHashRing(nil),
+		changed:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Run updates the replicas until the context is canceled.
+func (c *shardedCoordinator) Run(ctx context.Context) {
+	for {
+		err := c.updateMembers()
+		if err != nil {
+			glog.Errorf("Failed to update replicas of service %s/%s: %v", c.namespace, c.name, err)
+		}
+
+		select {
+		case <-time.After(shardMembersInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updateMembers rebuilds the hash ring if the ready replicas changed.
+func (c *shardedCoordinator) updateMembers() error {
+	endpoints, err := c.client.CoreV1().Endpoints(c.namespace).Get(c.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			members = append(members, net.JoinHostPort(address.IP, c.port))
+		}
+	}
+	sort.Strings(members)
+
+	c.Lock()
+	if reflect.DeepEqual(members, c.ring.members) {
+		c.Unlock()
+		return nil
+	}
+	c.ring = newHashRing(members)
+	c.Unlock()
+
+	glog.Infof("Sharding HPAs between %d replica(s): %v", len(members), members)
+	notifyChanged(c.changed)
+	return nil
+}
+
+// Owns returns true if the HPA is assigned to this replica.
+func (c *shardedCoordinator) Owns(namespace, name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ring.owner(namespace+"/"+name) == c.address
+}
+
+// Peers returns the addresses of all other replicas.
+func (c *shardedCoordinator) Peers() []string {
+	c.RLock()
+	defer c.RUnlock()
+
+	peers := make([]string, 0, len(c.ring.members))
+	for _, member := range c.ring.members {
+		if member != c.address {
+			peers = append(peers, member)
+		}
+	}
+	return peers
+}
+
+func (c *shardedCoordinator) Changed() <-chan struct{} {
+	return c.changed
+}
+
+func (c *shardedCoordinator) Token() string {
+	return c.token
+}
+
+// hashRing assigns keys to members by consistent hashing, such that only the
+// keys of a joining or leaving member move.
+type hashRing struct {
+	members []string
+	points  []uint32
+	owners  map[uint32]string
+}
+
+func newHashRing(members []string) *hashRing {
+	ring := &hashRing{
+		members: members,
+		points:  make([]uint32, 0, len(members)*shardVirtualNodes),
+		owners:  make(map[uint32]string, len(members)*shardVirtualNodes),
+	}
+
+	for _, member := range members {
+		for i := 0; i < shardVirtualNodes; i++ {
+			point := hashKey(member + "#" + strconv.Itoa(i))
+			ring.points = append(ring.points, point)
+			ring.owners[point] = member
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+
+	return ring
+}
+
+// owner returns the member owning the key or an empty string if the ring has
+// no members.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hashKey returns the position of a key on the ring. FNV spreads the similar
+// keys of the virtual nodes unevenly, so the first bytes of a SHA-256 are
+// used instead.
+func hashKey(key string) uint32 {
+	hash := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(hash[:4])
+}
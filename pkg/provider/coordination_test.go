@@ -0,0 +1,210 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestHashRingDistribution(t *testing.T) {
+	members := []string{"10.0.0.1:9096", "10.0.0.2:9096", "10.0.0.3:9096"}
+	ring := newHashRing(members)
+
+	keys := 3000
+	counts := map[string]int{}
+	for i := 0; i < keys; i++ {
+		counts[ring.owner(fmt.Sprintf("default/hpa-%d", i))]++
+	}
+
+	for _, member := range members {
+		share := float64(counts[member]) / float64(keys)
+		if share < 0.2 || share > 0.47 {
+			t.Errorf("expected member %s to own about a third of the keys, got %.2f", member, share)
+		}
+	}
+
+	if owner := newHashRing(nil).owner("default/hpa"); owner != "" {
+		t.Errorf("expected no owner on an empty ring, got %s", owner)
+	}
+}
+
+func TestHashRingStability(t *testing.T) {
+	members := []string{"10.0.0.1:9096", "10.0.0.2:9096", "10.0.0.3:9096"}
+	joined := "10.0.0.4:9096"
+
+	before := newHashRing(members)
+	after := newHashRing(append(append([]string{}, members...), joined))
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("default/hpa-%d", i)
+		if before.owner(key) == after.owner(key) {
+			continue
+		}
+
+		moved++
+		// only keys of the joining member move when it joins, and
+		// only those move back when it leaves.
+		if after.owner(key) != joined {
+			t.Errorf("expected key %s to move to %s, moved to %s", key, joined, after.owner(key))
+		}
+	}
+
+	if moved == 0 {
+		t.Error("expected keys to move to the joining member")
+	}
+
+	if owner := newHashRing(members).owner("default/hpa-1"); owner != before.owner("default/hpa-1") {
+		t.Errorf("expected the same owner for the same members, got %s and %s", owner, before.owner("default/hpa-1"))
+	}
+}
+
+// memoryLock is a leader election lock stored in memory.
+type memoryLock struct {
+	record  *resourcelock.LeaderElectionRecord
+	updates int
+	sync.Mutex
+}
+
+func (l *memoryLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.record == nil {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "test")
+	}
+	record := *l.record
+	return &record, nil
+}
+
+func (l *memoryLock) Create(record resourcelock.LeaderElectionRecord) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.record != nil {
+		return apierrors.NewAlreadyExists(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, "test")
+	}
+	l.record = &record
+	l.updates++
+	return nil
+}
+
+func (l *memoryLock) Update(record resourcelock.LeaderElectionRecord) error {
+	l.Lock()
+	defer l.Unlock()
+	l.record = &record
+	l.updates++
+	return nil
+}
+
+func (l *memoryLock) Describe() string {
+	return "memory"
+}
+
+func (l *memoryLock) getUpdates() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.updates
+}
+
+func newTestLeaderCoordinator(lock leaderLock, address string) *leaderCoordinator {
+	c := newLeaderCoordinatorWithLock(lock, CoordinationConfig{Address: address})
+	c.elector.leaseDuration = time.Second
+	c.elector.renewDeadline = 500 * time.Millisecond
+	c.elector.retryPeriod = 20 * time.Millisecond
+	return c
+}
+
+// waitFor polls the condition until it's true or the timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, description string, condition func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLeaderCoordinatorHandover(t *testing.T) {
+	lock := &memoryLock{}
+	first := newTestLeaderCoordinator(lock, "10.0.0.1:9096")
+	second := newTestLeaderCoordinator(lock, "10.0.0.2:9096")
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstStopped := make(chan struct{})
+	go func() {
+		defer close(firstStopped)
+		first.Run(firstCtx)
+	}()
+
+	waitFor(t, 2*time.Second, "first replica to lead", func() bool { return first.Owns("default", "app") })
+	<-first.Changed()
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.Run(secondCtx)
+
+	// the second replica follows the leader while its lease is renewed.
+	waitFor(t, 2*time.Second, "second replica to observe the leader", func() bool { return len(second.Peers()) == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if second.Owns("default", "app") {
+		t.Fatal("expected second replica not to lead while the lease is held")
+	}
+	if peers := second.Peers(); len(peers) != 1 || peers[0] != "10.0.0.1:9096" {
+		t.Errorf("expected the leader as peer, got %v", peers)
+	}
+	if peers := first.Peers(); len(peers) != 0 {
+		t.Errorf("expected no peers for the leader, got %v", peers)
+	}
+
+	// the stopped leader releases the lock, so the second replica takes
+	// over before the lease expires.
+	stopFirst()
+	<-firstStopped
+	if first.Owns("default", "app") {
+		t.Error("expected stopped replica not to lead")
+	}
+
+	waitFor(t, 500*time.Millisecond, "second replica to take over", func() bool { return second.Owns("default", "app") })
+	if peers := second.Peers(); len(peers) != 0 {
+		t.Errorf("expected no peers for the new leader, got %v", peers)
+	}
+}
+
+func TestLeaderCoordinatorStop(t *testing.T) {
+	lock := &memoryLock{}
+	c := newTestLeaderCoordinator(lock, "10.0.0.1:9096")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.Run(ctx)
+	}()
+
+	waitFor(t, 2*time.Second, "replica to lead", func() bool { return c.Owns("default", "app") })
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected leader election to stop")
+	}
+
+	// the stopped elector doesn't try to acquire the lock anymore.
+	updates := lock.getUpdates()
+	time.Sleep(100 * time.Millisecond)
+	if lock.getUpdates() != updates {
+		t.Error("expected no updates of the lock after stop")
+	}
+
+	record, _ := lock.Get()
+	if record.HolderIdentity != "" {
+		t.Errorf("expected released lock, held by %s", record.HolderIdentity)
+	}
+}
@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+// collectorInfo describes a scheduled collector and its live state.
+type collectorInfo struct {
+	Namespace           string                              `json:"namespace"`
+	HPA                 string                              `json:"hpa"`
+	MetricName          string                              `json:"metricName"`
+	MetricType          autoscalingv2beta1.MetricSourceType `json:"metricType"`
+	MetricLabels        string                              `json:"metricLabels,omitempty"`
+	Collector           string                              `json:"collector"`
+	Interval            string                              `json:"interval"`
+	Subscribers         int                                 `json:"subscribers"`
+	Backend             string                              `json:"backend,omitempty"`
+	CircuitBreaker      string                              `json:"circuitBreaker,omitempty"`
+	LastRun             *time.Time                          `json:"lastRun,omitempty"`
+	LastDuration        string                              `json:"lastDuration,omitempty"`
+	LastError           string                              `json:"lastError,omitempty"`
+	ConsecutiveFailures int                                 `json:"consecutiveFailures"`
+	NextRun             *time.Time                          `json:"nextRun,omitempty"`
+	Values              []storedValueInfo                   `json:"values"`
+}
+
+// storedValueInfo describes a value of a collector in the metric store.
+type storedValueInfo struct {
+	Object    *custom_metrics.ObjectReference `json:"object,omitempty"`
+	Labels    map[string]string               `json:"labels,omitempty"`
+	Value     string                          `json:"value"`
+	Timestamp time.Time                       `json:"timestamp"`
+	Expires   time.Time                       `json:"expires"`
+}
+
+// collectorInfos returns information about the scheduled collectors,
+// optionally filtered by the namespace and name of the HPA.
+func (p *HPAProvider) collectorInfos(scheduler *CollectorScheduler, namespace, name string) []collectorInfo {
+	subscriptions := scheduler.collectors(namespace, name)
+
+	infos := make([]collectorInfo, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		infos = append(infos, p.collectorInfo(sub))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Namespace != infos[j].Namespace {
+			return infos[i].Namespace < infos[j].Namespace
+		}
+		if infos[i].HPA != infos[j].HPA {
+			return infos[i].HPA < infos[j].HPA
+		}
+		if infos[i].MetricName != infos[j].MetricName {
+			return infos[i].MetricName < infos[j].MetricName
+		}
+		return infos[i].MetricLabels < infos[j].MetricLabels
+	})
+
+	return infos
+}
+
+// subscription is a collector scheduled for a metric of an HPA.
+type subscription struct {
+	hpa         resourceReference
+	key         metricKey
+	scheduled   *scheduledCollector
+	subscribers int
+}
+
+// collectors returns the scheduled collectors, optionally filtered by the
+// namespace and name of the HPA. A collector shared by several HPAs is
+// returned once for every HPA.
+func (t *CollectorScheduler) collectors(namespace, name string) []subscription {
+	t.RLock()
+	defer t.RUnlock()
+
+	var subscriptions []subscription
+	for ref, collectors := range t.table {
+		if namespace != "" && ref.Namespace != namespace {
+			continue
+		}
+
+		if name != "" && ref.Name != name {
+			continue
+		}
+
+		for key, scheduled := range collectors {
+			subscriptions = append(subscriptions, subscription{
+				hpa:         ref,
+				key:         key,
+				scheduled:   scheduled,
+				subscribers: scheduled.refs,
+			})
+		}
+	}
+
+	return subscriptions
+}
+
+func (p *HPAProvider) collectorInfo(sub subscription) collectorInfo {
+	s := sub.scheduled
+	info := collectorInfo{
+		Namespace:    sub.hpa.Namespace,
+		HPA:          sub.hpa.Name,
+		MetricName:   sub.key.Name,
+		MetricType:   sub.key.Type,
+		MetricLabels: sub.key.Labels,
+		Collector:    fmt.Sprintf("%T", s.collector),
+		Interval:     s.collector.Interval().String(),
+		Subscribers:  sub.subscribers,
+		Values:       []storedValueInfo{},
+	}
+
+	if s.breaker != nil {
+		info.Backend = s.breaker.backend
+		info.CircuitBreaker = s.breaker.State().String()
+	}
+
+	s.state.Lock()
+	if !s.state.LastRun.IsZero() {
+		lastRun := s.state.LastRun
+		info.LastRun = &lastRun
+		info.LastDuration = s.state.LastDuration.String()
+	}
+	if s.state.LastError != nil {
+		info.LastError = s.state.LastError.Error()
+	}
+	info.ConsecutiveFailures = s.state.ConsecutiveFailures
+	if !s.state.NextRun.IsZero() {
+		nextRun := s.state.NextRun
+		info.NextRun = &nextRun
+	}
+	lastValues := s.state.LastValues
+	s.state.Unlock()
+
+	for _, value := range lastValues {
+		stored, ok := p.metricStore.lookup(value)
+		if !ok {
+			continue
+		}
+
+		valueInfo := storedValueInfo{
+			Value:     stored.Value.String(),
+			Timestamp: stored.Timestamp,
+			Expires:   stored.Expires,
+		}
+
+		switch value.Type {
+		case autoscalingv2beta1.ObjectMetricSourceType, autoscalingv2beta1.PodsMetricSourceType:
+			object := value.Custom.DescribedObject
+			valueInfo.Object = &object
+		case autoscalingv2beta1.ExternalMetricSourceType:
+			valueInfo.Labels = value.External.MetricLabels
+		}
+
+		info.Values = append(info.Values, valueInfo)
+	}
+
+	return info
+}
+
+// DebugHandler returns a read-only HTTP handler serving the scheduled
+// collectors and their state as JSON on /debug/collectors. The collectors can
+// be filtered with the namespace and hpa query parameters.
+func (p *HPAProvider) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/collectors", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		scheduler := p.scheduler()
+		if scheduler == nil {
+			http.Error(w, "collector scheduler not started", http.StatusServiceUnavailable)
+			return
+		}
+
+		query := r.URL.Query()
+		infos := p.collectorInfos(scheduler, query.Get("namespace"), query.Get("hpa"))
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(infos)
+		if err != nil {
+			glog.Errorf("Failed to write collectors debug response: %v", err)
+		}
+	})
+	return mux
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+)
+
+// staticCollector is a collector returning no metrics.
+type staticCollector struct{}
+
+func (c staticCollector) GetMetrics() ([]collector.CollectedMetric, error) {
+	return nil, nil
+}
+
+func (c staticCollector) Interval() time.Duration {
+	return time.Minute
+}
+
+func TestDebugHandlerFilters(t *testing.T) {
+	key := func(name string) metricKey {
+		return metricKey{MetricTypeName: collector.MetricTypeName{Type: autoscalingv2beta1.ExternalMetricSourceType, Name: name}}
+	}
+
+	shared := &scheduledCollector{collector: staticCollector{}, refs: 2}
+	p := &HPAProvider{
+		metricStore: NewMetricStore(nil),
+		collectorScheduler: &CollectorScheduler{
+			table: map[resourceReference]map[metricKey]*scheduledCollector{
+				{Namespace: "default", Name: "app"}: {
+					key("queue-length"): shared,
+					key("requests"):     {collector: staticCollector{}, refs: 1},
+				},
+				{Namespace: "default", Name: "worker"}: {
+					key("queue-length"): shared,
+				},
+				{Namespace: "jobs", Name: "app"}: {
+					key("queue-length"): {collector: staticCollector{}, refs: 1},
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "all",
+			expected: []string{"default/app/queue-length", "default/app/requests", "default/worker/queue-length", "jobs/app/queue-length"},
+		},
+		{
+			name:     "namespace",
+			query:    "?namespace=default",
+			expected: []string{"default/app/queue-length", "default/app/requests", "default/worker/queue-length"},
+		},
+		{
+			name:     "hpa",
+			query:    "?hpa=app",
+			expected: []string{"default/app/queue-length", "default/app/requests", "jobs/app/queue-length"},
+		},
+		{
+			name:     "namespace and hpa",
+			query:    "?namespace=default&hpa=worker",
+			expected: []string{"default/worker/queue-length"},
+		},
+		{
+			name:     "no match",
+			query:    "?namespace=other",
+			expected: []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/collectors"+tc.query, nil))
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+			}
+
+			var infos []collectorInfo
+			err := json.Unmarshal(recorder.Body.Bytes(), &infos)
+			if err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+
+			collectors := make([]string, 0, len(infos))
+			for _, info := range infos {
+				collectors = append(collectors, info.Namespace+"/"+info.HPA+"/"+info.MetricName)
+				if info.MetricName == "queue-length" && info.Namespace == "default" && info.Subscribers != 2 {
+					t.Errorf("expected 2 subscribers of the shared collector, got %d", info.Subscribers)
+				}
+			}
+
+			if !equalStrings(collectors, tc.expected) {
+				t.Errorf("expected collectors %v, got %v", tc.expected, collectors)
+			}
+		})
+	}
+}
+
+func TestDebugHandlerErrors(t *testing.T) {
+	p := &HPAProvider{metricStore: NewMetricStore(nil)}
+
+	recorder := httptest.NewRecorder()
+	p.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/collectors", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d before the scheduler is started, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	p.DebugHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/collectors", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for POST, got %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+}
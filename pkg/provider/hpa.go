@@ -3,6 +3,7 @@ package provider
 import (
 	"context"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
@@ -10,10 +11,15 @@ import (
 	"github.com/kubernetes-incubator/custom-metrics-apiserver/pkg/provider"
 	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
 	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	clientv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 )
@@ -29,47 +35,110 @@ type HPAProvider struct {
 	interval           time.Duration
 	collectorScheduler *CollectorScheduler
 	collectorInterval  time.Duration
-	metricSink         chan metricCollection
+	metricSink         *metricQueue
 	hpaCache           map[resourceReference]autoscalingv2beta1.HorizontalPodAutoscaler
 	metricStore        *MetricStore
 	collectorFactory   *collector.CollectorFactory
+	recorder           record.EventRecorder
+	schedulerConfig    CollectorSchedulerConfig
+	// coordinator decides which HPAs are collected by this replica. All
+	// HPAs are collected if it's nil.
+	coordinator Coordinator
+	// persistence saves snapshots of the metric store if it's not nil.
+	persistence StorePersistence
+	// schedulerLock guards collectorScheduler which is read by the debug
+	// handler.
+	schedulerLock sync.RWMutex
 }
 
-// metricCollection is a container for sending collected metrics across a
-// channel.
+// metricCollection is a container for sending collected metrics through the
+// metric queue.
 type metricCollection struct {
 	Values []collector.CollectedMetric
 	Error  error
+	// Owner identifies the collector in the metric store.
+	Owner    string
+	Enqueued time.Time
 }
 
 // NewHPAProvider initializes a new HPAProvider.
-func NewHPAProvider(client kubernetes.Interface, interval, collectorInterval time.Duration, collectorFactory *collector.CollectorFactory) *HPAProvider {
-	metricsc := make(chan metricCollection)
+func NewHPAProvider(client kubernetes.Interface, mapper meta.RESTMapper, interval, collectorInterval time.Duration, collectorFactory *collector.CollectorFactory, schedulerConfig CollectorSchedulerConfig, coordinator Coordinator, persistence StorePersistence) *HPAProvider {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&clientv1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: "kube-metrics-adapter"})
+
 	return &HPAProvider{
 		client:            client,
 		interval:          interval,
 		collectorInterval: collectorInterval,
-		metricSink:        metricsc,
-		metricStore:       NewMetricStore(),
+		metricSink:        newMetricQueue(metricQueueSize),
+		metricStore:       NewMetricStore(mapper),
 		collectorFactory:  collectorFactory,
+		recorder:          recorder,
+		schedulerConfig:   schedulerConfig,
+		coordinator:       coordinator,
+		persistence:       persistence,
 	}
 }
 
-// Run runs the HPA resource discovery and metric collection.
+// Run runs the HPA resource discovery and metric collection until the
+// context is canceled. It returns once the last snapshot of the metric store
+// is saved and the coordinator is stopped.
 func (p *HPAProvider) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// restore the metric store before the first metrics are collected,
+	// such that newer values are not overwritten.
+	restored := false
+	if p.persistence != nil {
+		err := p.restoreStore()
+		if err != nil {
+			glog.Errorf("Failed to restore metric store snapshot: %v", err)
+		}
+		restored = err == nil
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.persistStore(ctx)
+		}()
+	}
+
 	// initialize collector table
-	p.collectorScheduler = NewCollectorScheduler(ctx, p.metricSink)
+	p.schedulerLock.Lock()
+	p.collectorScheduler = NewCollectorScheduler(ctx, p.metricSink, p.metricStore, p.schedulerConfig)
+	p.schedulerLock.Unlock()
 
 	go p.collectMetrics(ctx)
 
+	var changed <-chan struct{}
+	if p.coordinator != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.coordinator.Run(ctx)
+		}()
+		go p.syncPeers(ctx)
+		changed = p.coordinator.Changed()
+	}
+
 	for {
 		err := p.updateHPAs()
 		if err != nil {
 			glog.Error(err)
+		} else if restored {
+			// the restored metrics are replaced by the collectors of
+			// the HPAs, metrics of removed HPAs are purged once all
+			// collectors have collected.
+			p.metricStore.Replace(storeSnapshotOwner, p.scheduler().owners()...)
+			restored = false
 		}
 
 		select {
 		case <-time.After(p.interval):
+		case <-changed:
+			glog.Info("HPAs owned by this replica changed")
 		case <-ctx.Done():
 			glog.Info("Stopped HPA provider.")
 			return
@@ -77,8 +146,16 @@ func (p *HPAProvider) Run(ctx context.Context) {
 	}
 }
 
+// scheduler returns the collector scheduler or nil if the provider is not
+// running yet.
+func (p *HPAProvider) scheduler() *CollectorScheduler {
+	p.schedulerLock.RLock()
+	defer p.schedulerLock.RUnlock()
+	return p.collectorScheduler
+}
+
 // updateHPAs discovers all HPA resources and sets up metric collectors for new
-// HPAs.
+// HPAs. HPAs owned by other replicas are treated as removed.
 func (p *HPAProvider) updateHPAs() error {
 	glog.Info("Looking for HPAs")
 
@@ -98,6 +175,10 @@ func (p *HPAProvider) updateHPAs() error {
 			Namespace: hpa.Namespace,
 		}
 
+		if p.coordinator != nil && !p.coordinator.Owns(hpa.Namespace, hpa.Name) {
+			continue
+		}
+
 		if cachedHPA, ok := p.hpaCache[resourceRef]; !ok || !equalHPA(cachedHPA, hpa) {
 			metricConfigs, err := collector.ParseHPAMetrics(&hpa)
 			if err != nil {
@@ -106,7 +187,12 @@ func (p *HPAProvider) updateHPAs() error {
 			}
 
 			cache := true
+			keys := make(map[metricKey]bool, len(metricConfigs))
 			for _, config := range metricConfigs {
+				// keep the previous collector of a metric if the
+				// new one can't be created.
+				keys[newMetricKey(config)] = true
+
 				interval := config.Interval
 				if interval == 0 {
 					interval = p.collectorInterval
@@ -114,15 +200,16 @@ func (p *HPAProvider) updateHPAs() error {
 
 				collector, err := p.collectorFactory.NewCollector(&hpa, config, interval)
 				if err != nil {
-					// TODO: log and send event
-					glog.Errorf("Failed to create new metrics collector: %v", err)
+					glog.Errorf("Failed to create new metrics collector for metric '%s' of HPA %s/%s: %v", config.Name, hpa.Namespace, hpa.Name, err)
+					p.recorder.Eventf(&hpa, apiv1.EventTypeWarning, "CreateNewMetricsCollector", "Failed to create new metrics collector for metric '%s': %v", config.Name, err)
 					cache = false
 					continue
 				}
 
 				glog.Infof("Adding new metrics collector: %T", collector)
-				p.collectorScheduler.Add(resourceRef, config.MetricTypeName, collector)
+				p.collectorScheduler.Add(resourceRef, newMetricKey(config), collector)
 			}
+			p.collectorScheduler.Retain(resourceRef, keys)
 			newHPAs++
 
 			// if we get an error setting up the collectors for the
@@ -176,17 +263,22 @@ func (p *HPAProvider) collectMetrics(ctx context.Context) {
 	}(ctx)
 
 	for {
-		select {
-		case collection := <-p.metricSink:
+		batch, ok := p.metricSink.Batch(ctx, metricQueueBatchSize)
+		if !ok {
+			glog.Info("Stopped metrics collection.")
+			return
+		}
+
+		inserted := 0
+		for _, collection := range batch {
 			if collection.Error != nil {
 				glog.Errorf("Failed to collect metrics: %v", collection.Error)
 			}
 
-			glog.Infof("Collected %d new metric(s)", len(collection.Values))
 			for _, value := range collection.Values {
 				switch value.Type {
 				case autoscalingv2beta1.ObjectMetricSourceType, autoscalingv2beta1.PodsMetricSourceType:
-					glog.Infof("Collected new custom metric '%s' (%s) for %s %s/%s",
+					glog.V(2).Infof("Collected new custom metric '%s' (%s) for %s %s/%s",
 						value.Custom.MetricName,
 						value.Custom.Value.String(),
 						value.Custom.DescribedObject.Kind,
@@ -194,18 +286,22 @@ func (p *HPAProvider) collectMetrics(ctx context.Context) {
 						value.Custom.DescribedObject.Name,
 					)
 				case autoscalingv2beta1.ExternalMetricSourceType:
-					glog.Infof("Collected new external metric '%s' (%s) [%s]",
+					glog.V(2).Infof("Collected new external metric '%s' (%s) [%s]",
 						value.External.MetricName,
 						value.External.Value.String(),
 						labels.Set(value.External.MetricLabels).String(),
 					)
 				}
-				p.metricStore.Insert(value)
+				p.metricStore.insert(value, time.Now().UTC().Add(metricTTL), collection.Owner)
+				inserted++
+			}
+
+			if collection.Error == nil {
+				p.metricStore.Collected(collection.Owner)
 			}
-		case <-ctx.Done():
-			glog.Info("Stopped metrics collection.")
-			return
 		}
+
+		glog.V(1).Infof("Collected %d new metric(s) from %d collection(s)", inserted, len(batch))
 	}
 }
 
@@ -242,79 +338,269 @@ type resourceReference struct {
 	Namespace string
 }
 
+// metricKey identifies a metric of an HPA. External metrics with the same
+// name are distinguished by the labels of their metric selector.
+type metricKey struct {
+	collector.MetricTypeName
+	Labels string
+}
+
+func newMetricKey(config *collector.MetricConfig) metricKey {
+	return metricKey{
+		MetricTypeName: config.MetricTypeName,
+		Labels:         hashLabelMap(config.Labels),
+	}
+}
+
+// CollectorSchedulerConfig configures how many collectors run at the same
+// time and when the collectors of a failing backend are paused.
+type CollectorSchedulerConfig struct {
+	// Workers is the number of collectors running at the same time.
+	Workers int
+	// BackendConcurrency is the maximum number of collectors of the same
+	// backend running at the same time. 0 means no limit.
+	BackendConcurrency int
+	// BackendRateLimit is the maximum number of collector runs per second
+	// for the same backend. 0 means no limit.
+	BackendRateLimit float64
+	// BreakerThreshold is the number of consecutive failures of a backend
+	// after which its circuit breaker opens. 0 disables the circuit
+	// breakers.
+	BreakerThreshold int
+	// BreakerTimeout is the time a circuit breaker stays open before a
+	// single collector is allowed to try the backend again.
+	BreakerTimeout time.Duration
+}
+
 // CollectorScheduler is a scheduler for running metric collection jobs.
 // It keeps track of all running collectors and stops them if they are to be
-// removed.
+// removed. Collectors with the same fingerprint are shared between HPAs and
+// only run once. The collectors are run by a fixed pool of workers.
 type CollectorScheduler struct {
-	ctx        context.Context
-	table      map[resourceReference]map[collector.MetricTypeName]context.CancelFunc
-	metricSink chan<- metricCollection
+	table    map[resourceReference]map[metricKey]*scheduledCollector
+	shared   map[string]*scheduledCollector
+	pool     *workerPool
+	store    *MetricStore
+	breakers *circuitBreakers
+	limiters *backendLimiters
+	// started is the number of collectors started, used for
+	// identifying the owner of metrics in the store.
+	started uint64
 	sync.RWMutex
 }
 
-// NewCollectorScheudler initializes a new CollectorScheduler.
-func NewCollectorScheduler(ctx context.Context, metricsc chan<- metricCollection) *CollectorScheduler {
+// scheduledCollector is a running collector in the collector scheduler. It's
+// referenced from the table once for every HPA subscribed to it.
+type scheduledCollector struct {
+	collector   collector.Collector
+	fingerprint string
+	owner       string
+	refs        int
+	breaker     *circuitBreaker
+	limiter     *backendLimiter
+	state       collectorState
+
+	// next, index and stopped are guarded by the worker pool.
+	next    time.Time
+	index   int
+	stopped bool
+}
+
+// collectorState is the state of the last run of a collector.
+type collectorState struct {
+	LastRun             time.Time
+	LastDuration        time.Duration
+	LastError           error
+	LastValues          []collector.CollectedMetric
+	ConsecutiveFailures int
+	NextRun             time.Time
+	sync.Mutex
+}
+
+// NewCollectorScheudler initializes a new CollectorScheduler and starts its
+// workers. The workers are stopped when the context is canceled.
+func NewCollectorScheduler(ctx context.Context, metricSink *metricQueue, store *MetricStore, config CollectorSchedulerConfig) *CollectorScheduler {
+	pool := newWorkerPool(config.Workers, metricSink)
+	pool.Run(ctx)
+
 	return &CollectorScheduler{
-		ctx:        ctx,
-		table:      map[resourceReference]map[collector.MetricTypeName]context.CancelFunc{},
-		metricSink: metricsc,
+		table:    map[resourceReference]map[metricKey]*scheduledCollector{},
+		shared:   map[string]*scheduledCollector{},
+		pool:     pool,
+		store:    store,
+		breakers: newCircuitBreakers(config.BreakerThreshold, config.BreakerTimeout),
+		limiters: newBackendLimiters(config.BackendConcurrency, config.BackendRateLimit),
 	}
 }
 
 // Add adds a new collector to the collector scheduler. Once the collector is
-// added it will be started to collect metrics.
-func (t *CollectorScheduler) Add(resourceRef resourceReference, typeName collector.MetricTypeName, metricCollector collector.Collector) {
+// added it will be started to collect metrics. If a collector with the same
+// fingerprint is already running, the HPA subscribes to it instead.
+func (t *CollectorScheduler) Add(resourceRef resourceReference, key metricKey, metricCollector collector.Collector) {
 	t.Lock()
 	defer t.Unlock()
 
 	collectors, ok := t.table[resourceRef]
 	if !ok {
-		collectors = map[collector.MetricTypeName]context.CancelFunc{}
+		collectors = map[metricKey]*scheduledCollector{}
 		t.table[resourceRef] = collectors
 	}
 
-	if cancelCollector, ok := collectors[typeName]; ok {
-		// stop old collector
-		cancelCollector()
+	old, replaced := collectors[key]
+	scheduled := t.subscribe(metricCollector)
+	collectors[key] = scheduled
+
+	if replaced {
+		// stop old collector unless it's still used. Its metrics
+		// are served until the new collector has collected.
+		t.release(old, scheduled)
+	}
+}
+
+// subscribe returns the running collector with the same fingerprint as the
+// collector or starts the collector if there is none. The collector is closed
+// if it isn't started.
+func (t *CollectorScheduler) subscribe(metricCollector collector.Collector) *scheduledCollector {
+	var fingerprint string
+	if c, ok := metricCollector.(collector.FingerprintCollector); ok {
+		fingerprint = c.Fingerprint()
+	}
+
+	if scheduled, ok := t.shared[fingerprint]; ok {
+		closeCollector(metricCollector)
+		scheduled.refs++
+		glog.V(2).Infof("Sharing %T with fingerprint %s between %d HPAs", metricCollector, fingerprint, scheduled.refs)
+		return scheduled
+	}
+
+	t.started++
+	scheduled := &scheduledCollector{
+		collector:   metricCollector,
+		fingerprint: fingerprint,
+		owner:       "collector/" + strconv.FormatUint(t.started, 10),
+		refs:        1,
+		index:       -1,
 	}
 
-	ctx, cancel := context.WithCancel(t.ctx)
-	collectors[typeName] = cancel
+	if c, ok := metricCollector.(collector.BackendCollector); ok {
+		scheduled.breaker = t.breakers.Get(c.Backend())
+		scheduled.limiter = t.limiters.Get(c.Backend())
+	}
 
-	// start runner for new collector
-	go collectorRunner(ctx, metricCollector, t.metricSink)
+	if fingerprint != "" {
+		t.shared[fingerprint] = scheduled
+	}
+
+	// queue the first run of the new collector
+	t.pool.schedule(scheduled, initialDelay(metricCollector.Interval()))
+
+	return scheduled
 }
 
-// collectorRunner runs a collector at the desirec interval. If the passed
-// context is canceled the collection will be stopped.
-func collectorRunner(ctx context.Context, collector collector.Collector, metricsc chan<- metricCollection) {
-	for {
-		values, err := collector.GetMetrics()
+// release unsubscribes an HPA from a collector. The collector is stopped once
+// the last HPA is unsubscribed and its metrics are purged from the store,
+// unless they are also inserted by other collectors. If the collector is
+// replaced by a successor, its metrics are only purged once the successor has
+// collected, such that the metrics don't disappear in between.
+func (t *CollectorScheduler) release(scheduled, successor *scheduledCollector) {
+	scheduled.refs--
+	if scheduled.refs > 0 {
+		return
+	}
 
-		metricsc <- metricCollection{
-			Values: values,
-			Error:  err,
-		}
+	t.pool.stop(scheduled)
+	closeCollector(scheduled.collector)
+	if t.shared[scheduled.fingerprint] == scheduled {
+		delete(t.shared, scheduled.fingerprint)
+	}
 
-		select {
-		case <-time.After(collector.Interval()):
-		case <-ctx.Done():
-			glog.V(2).Infof("stopping collector runner...")
-			return
-		}
+	if successor != nil {
+		t.store.Replace(scheduled.owner, successor.owner)
+		return
 	}
+	t.store.Purge(scheduled.owner)
 }
 
-// Remove removes a collector from the Collector schduler. The collector is
-// stopped before it's removed.
+// closeCollector releases the resources of a collector which isn't used
+// anymore.
+func closeCollector(metricCollector collector.Collector) {
+	if c, ok := metricCollector.(collector.ClosableCollector); ok {
+		c.Close()
+	}
+}
+
+// record records the result of a run started at start and returns the number
+// of consecutive failures.
+func (s *collectorState) record(start time.Time, values []collector.CollectedMetric, err error) int {
+	s.Lock()
+	defer s.Unlock()
+
+	s.LastRun = start
+	s.LastDuration = time.Since(start)
+	s.LastError = err
+	if err != nil {
+		s.ConsecutiveFailures++
+	} else {
+		s.ConsecutiveFailures = 0
+		s.LastValues = values
+	}
+
+	return s.ConsecutiveFailures
+}
+
+// scheduleNext records the time of the next run.
+func (s *collectorState) scheduleNext(wait time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.NextRun = time.Now().Add(wait)
+}
+
+// Remove removes the collectors of an HPA from the Collector schduler. The
+// collectors are stopped unless they are shared with other HPAs.
 func (t *CollectorScheduler) Remove(resourceRef resourceReference) {
 	t.Lock()
 	defer t.Unlock()
 
 	if collectors, ok := t.table[resourceRef]; ok {
-		for _, cancelCollector := range collectors {
-			cancelCollector()
+		for _, scheduled := range collectors {
+			t.release(scheduled, nil)
 		}
 		delete(t.table, resourceRef)
 	}
 }
+
+// Retain removes the collectors of an HPA except for the collectors of the
+// metrics in keep. It's used to remove the collectors of metrics which were
+// removed from an HPA.
+func (t *CollectorScheduler) Retain(resourceRef resourceReference, keep map[metricKey]bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	collectors := t.table[resourceRef]
+	for key, scheduled := range collectors {
+		if keep[key] {
+			continue
+		}
+
+		t.release(scheduled, nil)
+		delete(collectors, key)
+	}
+}
+
+// owners returns the owners of all scheduled collectors.
+func (t *CollectorScheduler) owners() []string {
+	t.RLock()
+	defer t.RUnlock()
+
+	seen := map[string]bool{}
+	var owners []string
+	for _, collectors := range t.table {
+		for _, scheduled := range collectors {
+			if !seen[scheduled.owner] {
+				seen[scheduled.owner] = true
+				owners = append(owners, scheduled.owner)
+			}
+		}
+	}
+	return owners
+}
@@ -0,0 +1,331 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaderLock is the lock of the leader election. It stores the record of the
+// current leader.
+type leaderLock interface {
+	Get() (*resourcelock.LeaderElectionRecord, error)
+	Create(record resourcelock.LeaderElectionRecord) error
+	Update(record resourcelock.LeaderElectionRecord) error
+	Describe() string
+}
+
+// leaderElector takes part in the leader election. Unlike the elector of
+// client-go v8 it stops when its context is canceled and becomes a candidate
+// again when it lost the leadership.
+type leaderElector struct {
+	lock          leaderLock
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+	// onChanged is called when this replica started or stopped leading.
+	onChanged func(leading bool)
+
+	// observedRecord is the last record read from or written to the lock,
+	// observedTime the local time it was observed at. The local time is
+	// used for expiring the lease so the clocks of the replicas don't need
+	// to be in sync.
+	observedRecord resourcelock.LeaderElectionRecord
+	observedTime   time.Time
+	leading        bool
+	sync.RWMutex
+}
+
+// Run acquires and renews the lock until the context is canceled. The lock
+// is released when the context is canceled, such that another replica can
+// take over right away.
+func (e *leaderElector) Run(ctx context.Context) {
+	var lastRenew time.Time
+	for {
+		now := time.Now()
+		if e.tryAcquireOrRenew(now) {
+			lastRenew = now
+			e.setLeading(true)
+		} else if e.IsLeader() && now.Sub(lastRenew) > e.renewDeadline {
+			e.setLeading(false)
+		}
+
+		select {
+		case <-time.After(e.retryPeriod):
+		case <-ctx.Done():
+			if e.IsLeader() {
+				e.setLeading(false)
+				err := e.release()
+				if err != nil {
+					glog.Errorf("Failed to release leader election lock %s: %v", e.lock.Describe(), err)
+				}
+			}
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew acquires the lock if it's free or expired, or renews it
+// if it's held by this replica. It returns true if this replica holds the
+// lock.
+func (e *leaderElector) tryAcquireOrRenew(now time.Time) bool {
+	record := resourcelock.LeaderElectionRecord{
+		HolderIdentity:       e.identity,
+		LeaseDurationSeconds: int(e.leaseDuration / time.Second),
+		AcquireTime:          metav1.NewTime(now),
+		RenewTime:            metav1.NewTime(now),
+	}
+
+	old, err := e.lock.Get()
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			glog.Errorf("Failed to get leader election lock %s: %v", e.lock.Describe(), err)
+			return false
+		}
+
+		err = e.lock.Create(record)
+		if err != nil {
+			glog.Errorf("Failed to create leader election lock %s: %v", e.lock.Describe(), err)
+			return false
+		}
+		e.observe(record, now)
+		return true
+	}
+
+	e.Lock()
+	if !reflect.DeepEqual(e.observedRecord, *old) {
+		if e.observedRecord.HolderIdentity != old.HolderIdentity && old.HolderIdentity != "" {
+			glog.Infof("New leader elected: %s", old.HolderIdentity)
+		}
+		e.observedRecord = *old
+		e.observedTime = now
+	}
+	expires := e.observedTime.Add(time.Duration(old.LeaseDurationSeconds) * time.Second)
+	e.Unlock()
+
+	if old.HolderIdentity != "" && old.HolderIdentity != e.identity && expires.After(now) {
+		return false
+	}
+
+	if old.HolderIdentity == e.identity {
+		record.AcquireTime = old.AcquireTime
+		record.LeaderTransitions = old.LeaderTransitions
+	} else {
+		record.LeaderTransitions = old.LeaderTransitions + 1
+	}
+
+	err = e.lock.Update(record)
+	if err != nil {
+		glog.Errorf("Failed to update leader election lock %s: %v", e.lock.Describe(), err)
+		return false
+	}
+	e.observe(record, now)
+	return true
+}
+
+// release clears the holder of the lock if it's held by this replica.
+func (e *leaderElector) release() error {
+	record, err := e.lock.Get()
+	if err != nil {
+		return err
+	}
+
+	if record.HolderIdentity != e.identity {
+		return nil
+	}
+
+	now := metav1.Now()
+	released := resourcelock.LeaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    record.LeaderTransitions,
+	}
+	err = e.lock.Update(released)
+	if err != nil {
+		return err
+	}
+	e.observe(released, now.Time)
+	return nil
+}
+
+func (e *leaderElector) observe(record resourcelock.LeaderElectionRecord, now time.Time) {
+	e.Lock()
+	defer e.Unlock()
+	e.observedRecord = record
+	e.observedTime = now
+}
+
+func (e *leaderElector) setLeading(leading bool) {
+	e.Lock()
+	changed := e.leading != leading
+	e.leading = leading
+	e.Unlock()
+
+	if changed && e.onChanged != nil {
+		e.onChanged(leading)
+	}
+}
+
+// IsLeader returns true if this replica is the leader.
+func (e *leaderElector) IsLeader() bool {
+	e.RLock()
+	defer e.RUnlock()
+	return e.leading
+}
+
+// GetLeader returns the identity of the last observed leader.
+func (e *leaderElector) GetLeader() string {
+	e.RLock()
+	defer e.RUnlock()
+	return e.observedRecord.HolderIdentity
+}
+
+// lease is a coordination.k8s.io/v1 Lease. client-go v8 has no types for the
+// coordination API, so only the fields used for the leader election are
+// defined.
+type lease struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string           `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32            `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *metav1.MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *metav1.MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32            `json:"leaseTransitions,omitempty"`
+}
+
+// leaseLock is a leader election lock stored in a Lease. The Lease is
+// requested with a REST client of the API server since client-go v8 has no
+// client for the coordination API. Updates fail if the Lease was changed
+// since it was read.
+type leaseLock struct {
+	client    rest.Interface
+	namespace string
+	name      string
+	lease     *lease
+}
+
+func newLeaseLock(client rest.Interface, namespace, name string) *leaseLock {
+	return &leaseLock{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (l *leaseLock) path(name string) string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.namespace, name)
+}
+
+// Get returns the record of the Lease.
+func (l *leaseLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	data, err := l.client.Get().AbsPath(l.path(l.name)).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	var current lease
+	err = json.Unmarshal(data, &current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lease: %v", err)
+	}
+	l.lease = &current
+
+	return leaseToRecord(current.Spec), nil
+}
+
+// Create creates the Lease with the record.
+func (l *leaseLock) Create(record resourcelock.LeaderElectionRecord) error {
+	created := &lease{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"},
+		ObjectMeta: metav1.ObjectMeta{Name: l.name, Namespace: l.namespace},
+		Spec:       recordToLease(record),
+	}
+	return l.write(l.client.Post().AbsPath(l.path("")), created)
+}
+
+// Update updates the record of the Lease read by the last Get.
+func (l *leaseLock) Update(record resourcelock.LeaderElectionRecord) error {
+	if l.lease == nil {
+		return fmt.Errorf("lease %s not initialized, call Get first", l.Describe())
+	}
+
+	updated := *l.lease
+	updated.Spec = recordToLease(record)
+	return l.write(l.client.Put().AbsPath(l.path(l.name)), &updated)
+}
+
+// write sends the Lease with the request and stores the Lease returned.
+func (l *leaseLock) write(request *rest.Request, body *lease) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	data, err := request.SetHeader("Content-Type", "application/json").Body(encoded).Do().Raw()
+	if err != nil {
+		return err
+	}
+
+	var written lease
+	err = json.Unmarshal(data, &written)
+	if err != nil {
+		return fmt.Errorf("failed to parse lease: %v", err)
+	}
+	l.lease = &written
+	return nil
+}
+
+// Describe returns the namespace and name of the Lease.
+func (l *leaseLock) Describe() string {
+	return l.namespace + "/" + l.name
+}
+
+func leaseToRecord(spec leaseSpec) *resourcelock.LeaderElectionRecord {
+	var record resourcelock.LeaderElectionRecord
+	if spec.HolderIdentity != nil {
+		record.HolderIdentity = *spec.HolderIdentity
+	}
+	if spec.LeaseDurationSeconds != nil {
+		record.LeaseDurationSeconds = int(*spec.LeaseDurationSeconds)
+	}
+	if spec.AcquireTime != nil {
+		record.AcquireTime = metav1.NewTime(spec.AcquireTime.Time)
+	}
+	if spec.RenewTime != nil {
+		record.RenewTime = metav1.NewTime(spec.RenewTime.Time)
+	}
+	if spec.LeaseTransitions != nil {
+		record.LeaderTransitions = int(*spec.LeaseTransitions)
+	}
+	return &record
+}
+
+func recordToLease(record resourcelock.LeaderElectionRecord) leaseSpec {
+	holder := record.HolderIdentity
+	duration := int32(record.LeaseDurationSeconds)
+	transitions := int32(record.LeaderTransitions)
+	acquireTime := metav1.NewMicroTime(record.AcquireTime.Time)
+	renewTime := metav1.NewMicroTime(record.RenewTime.Time)
+	return leaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &duration,
+		AcquireTime:          &acquireTime,
+		RenewTime:            &renewTime,
+		LeaseTransitions:     &transitions,
+	}
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// metricQueueSize is the maximum number of metric collections waiting
+	// to be inserted into the metric store.
+	metricQueueSize = 1000
+	// metricQueueBatchSize is the maximum number of metric collections
+	// inserted into the metric store at once.
+	metricQueueBatchSize = 100
+)
+
+var (
+	metricQueueDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kube_metrics_adapter_metric_queue_dropped_total",
+			Help: "Number of metric collections dropped because the metric queue was full.",
+		},
+	)
+	metricQueueLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kube_metrics_adapter_metric_queue_length",
+			Help: "Number of metric collections waiting to be inserted into the metric store.",
+		},
+	)
+	metricQueueDelay = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "kube_metrics_adapter_metric_queue_delay_seconds",
+			Help:    "Time metric collections wait in the metric queue before they are inserted into the metric store.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricQueueDropped, metricQueueLength, metricQueueDelay)
+}
+
+// metricQueue is a bounded queue of metric collections. Collectors push
+// without blocking and the metric store consumes the queue in batches.
+type metricQueue struct {
+	collections chan metricCollection
+}
+
+func newMetricQueue(size int) *metricQueue {
+	return &metricQueue{
+		collections: make(chan metricCollection, size),
+	}
+}
+
+// Push adds a metric collection to the queue. It returns false if the queue
+// is full and the collection was dropped.
+func (q *metricQueue) Push(collection metricCollection) bool {
+	collection.Enqueued = time.Now()
+
+	select {
+	case q.collections <- collection:
+		return true
+	default:
+		metricQueueDropped.Inc()
+		return false
+	}
+}
+
+// Batch waits for at least one metric collection and returns up to max
+// collections. It returns false if the context is canceled.
+func (q *metricQueue) Batch(ctx context.Context, max int) ([]metricCollection, bool) {
+	var batch []metricCollection
+
+	select {
+	case collection := <-q.collections:
+		batch = append(batch, collection)
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	for len(batch) < max {
+		select {
+		case collection := <-q.collections:
+			batch = append(batch, collection)
+		default:
+			return q.observe(batch), true
+		}
+	}
+
+	return q.observe(batch), true
+}
+
+// observe records the length of the queue and the delay of a batch.
+func (q *metricQueue) observe(batch []metricCollection) []metricCollection {
+	metricQueueLength.Set(float64(len(q.collections)))
+	for _, collection := range batch {
+		metricQueueDelay.Observe(time.Since(collection.Enqueued).Seconds())
+	}
+	return batch
+}
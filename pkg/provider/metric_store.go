@@ -7,129 +7,582 @@ import (
 	"sync"
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/custom-metrics-apiserver/pkg/provider"
 	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
 	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 )
 
+const (
+	// metricTTL is the time after which a metric which is not collected
+	// again is removed from the store.
+	metricTTL = 15 * time.Minute // TODO: make TTL configurable
+	// metricStoreShards is the number of shards of the metric store.
+	// Metrics are assigned to a shard by name, such that metrics with
+	// different names don't contend for the same lock.
+	metricStoreShards = 16
+)
+
 // customMetricsStoredMetric is a wrapper around custom_metrics.MetricValue with a TTL used
-// to clean up stale metrics from the customMetricsStore.
+// to clean up stale metrics from the store.
 type customMetricsStoredMetric struct {
 	Value  custom_metrics.MetricValue
 	Labels map[string]string
 	TTL    time.Time
+	// Owners are the collectors which inserted the metric.
+	Owners map[string]bool
 }
 
 type externalMetricsStoredMetric struct {
-	Value external_metrics.ExternalMetricValue
-	TTL   time.Time
+	Value  external_metrics.ExternalMetricValue
+	TTL    time.Time
+	Owners map[string]bool
 }
 
-// MetricStore is a simple in-memory Metrics Store for HPA metrics.
-type MetricStore struct {
-	customMetricsStore   map[string]map[schema.GroupResource]map[string]map[string]customMetricsStoredMetric
-	externalMetricsStore map[string]map[string]externalMetricsStoredMetric
+// storeKey identifies a metric in the store.
+type storeKey struct {
+	metricName    string
+	groupResource schema.GroupResource
+	namespace     string
+	name          string
+	// labels identify external metrics, which don't describe an object.
+	labels   string
+	external bool
+}
+
+// scopeKey identifies the custom metrics of a resource in a namespace or the
+// external metrics of a name. Selector queries are limited to a scope.
+type scopeKey struct {
+	metricName    string
+	groupResource schema.GroupResource
+	namespace     string
+	external      bool
+}
+
+func (k storeKey) scope() scopeKey {
+	return scopeKey{
+		metricName:    k.metricName,
+		groupResource: k.groupResource,
+		namespace:     k.namespace,
+		external:      k.external,
+	}
+}
+
+// groupKey identifies the custom metrics of a resource in all namespaces.
+type groupKey struct {
+	metricName    string
+	groupResource schema.GroupResource
+}
+
+// labelKey identifies the metrics of a scope with a label value.
+type labelKey struct {
+	scope scopeKey
+	label string
+	value string
+}
+
+type keySet map[storeKey]struct{}
+
+// metricStoreShard holds the metrics of a subset of the metric names. The
+// metrics are stored by flat composite keys and indexed by scope and by label
+// value for selector queries.
+type metricStoreShard struct {
+	custom   map[storeKey]customMetricsStoredMetric
+	external map[storeKey]externalMetricsStoredMetric
+	// scopes are the metrics of each scope.
+	scopes map[scopeKey]keySet
+	// namespaces are the namespaces with custom metrics of each group.
+	namespaces map[groupKey]map[string]bool
+	// labels is an inverted index of the metrics by label value.
+	labels map[labelKey]keySet
+	// owned are the metrics inserted by each owner.
+	owned map[string]keySet
+	// purged are the owners purged from the store. Late inserts of these
+	// owners, e.g. of a collection in progress when the owner was purged,
+	// are ignored.
+	purged map[string]time.Time
 	sync.RWMutex
 }
 
-// NewMetricStore initializes an empty Metrics Store.
-func NewMetricStore() *MetricStore {
-	return &MetricStore{
-		customMetricsStore:   make(map[string]map[schema.GroupResource]map[string]map[string]customMetricsStoredMetric, 0),
-		externalMetricsStore: make(map[string]map[string]externalMetricsStoredMetric, 0),
+func newMetricStoreShard() *metricStoreShard {
+	return &metricStoreShard{
+		custom:     map[storeKey]customMetricsStoredMetric{},
+		external:   map[storeKey]externalMetricsStoredMetric{},
+		scopes:     map[scopeKey]keySet{},
+		namespaces: map[groupKey]map[string]bool{},
+		labels:     map[labelKey]keySet{},
+		owned:      map[string]keySet{},
+		purged:     map[string]time.Time{},
 	}
 }
 
-// Insert inserts a collected metric into the metric customMetricsStore.
+// MetricStore is a simple in-memory Metrics Store for HPA metrics. Metrics
+// are owned by the collectors which inserted them and are removed once all
+// owners are purged.
+type MetricStore struct {
+	shards [metricStoreShards]*metricStoreShard
+	// replaced are the owners replaced by each owner. They are purged
+	// once all of their successors have collected.
+	replaced map[string][]string
+	// successors are the owners each replaced owner is waiting for.
+	successors   map[string]map[string]bool
+	replacedLock sync.Mutex
+	// mapper resolves the resource of the objects described by custom
+	// metrics.
+	mapper meta.RESTMapper
+}
+
+// NewMetricStore initializes an empty Metrics Store. The mapper resolves the
+// resource of the objects described by custom metrics, other than pods and
+// ingresses.
+func NewMetricStore(mapper meta.RESTMapper) *MetricStore {
+	s := &MetricStore{
+		replaced:   map[string][]string{},
+		successors: map[string]map[string]bool{},
+		mapper:     mapper,
+	}
+	for i := range s.shards {
+		s.shards[i] = newMetricStoreShard()
+	}
+	return s
+}
+
+// shard returns the shard of the metrics with the name.
+func (s *MetricStore) shard(metricName string) *metricStoreShard {
+	return s.shards[hashKey(metricName)%metricStoreShards]
+}
+
+// Insert inserts a collected metric into the metric store.
 func (s *MetricStore) Insert(value collector.CollectedMetric) {
+	s.insert(value, time.Now().UTC().Add(metricTTL), "")
+}
+
+// insert inserts a collected metric which expires at ttl into the store. The
+// metric is owned by owner unless owner is empty, in which case it's only
+// removed once expired.
+func (s *MetricStore) insert(value collector.CollectedMetric, ttl time.Time, owner string) {
+	key, ok := s.key(value)
+	if !ok {
+		return
+	}
+
+	shard := s.shard(key.metricName)
+	shard.Lock()
+	defer shard.Unlock()
+	shard.insert(key, value, ttl, owner)
+}
+
+// key returns the key of a collected metric in the store. It returns false
+// if the metric can't be stored, e.g. because the resource of the described
+// object is unknown.
+func (s *MetricStore) key(value collector.CollectedMetric) (storeKey, bool) {
 	switch value.Type {
 	case autoscalingv2beta1.ObjectMetricSourceType, autoscalingv2beta1.PodsMetricSourceType:
-		s.insertCustomMetric(value.Custom, value.Labels)
+		groupResource, err := s.groupResource(value.Custom.DescribedObject)
+		if err != nil {
+			glog.Errorf("Failed to store metric %s: %v", value.Custom.MetricName, err)
+			return storeKey{}, false
+		}
+
+		return storeKey{
+			metricName:    value.Custom.MetricName,
+			groupResource: groupResource,
+			namespace:     value.Custom.DescribedObject.Namespace,
+			name:          value.Custom.DescribedObject.Name,
+		}, true
 	case autoscalingv2beta1.ExternalMetricSourceType:
-		s.insertExternalMetric(value.External)
+		return storeKey{
+			metricName: value.External.MetricName,
+			labels:     hashLabelMap(value.External.MetricLabels),
+			external:   true,
+		}, true
 	}
+	return storeKey{}, false
 }
 
-// insertCustomMetric inserts a custom metric plus labels into the store.
-func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue, labels map[string]string) {
-	s.Lock()
-	defer s.Unlock()
+// insert inserts a metric into the shard and its indexes. It returns false
+// if the owner was purged. The caller must hold the lock.
+func (sh *metricStoreShard) insert(key storeKey, value collector.CollectedMetric, ttl time.Time, owner string) bool {
+	if _, ok := sh.purged[owner]; ok && owner != "" {
+		return false
+	}
 
-	// TODO: handle this mapping nicer
-	var groupResource schema.GroupResource
-	switch value.DescribedObject.Kind {
-	case "Pod":
-		groupResource = schema.GroupResource{
-			Resource: "pods",
+	scope := key.scope()
+
+	if key.external {
+		previous, ok := sh.external[key]
+		sh.external[key] = externalMetricsStoredMetric{
+			Value:  value.External,
+			TTL:    ttl,
+			Owners: sh.own(previous.Owners, key, owner),
 		}
-	case "Ingress":
-		groupResource = schema.GroupResource{
-			Resource: "ingresses",
-			Group:    "extensions",
+
+		// the labels identify external metrics and can't change.
+		if !ok {
+			sh.index(scope, key, value.External.MetricLabels)
 		}
+		return true
 	}
 
-	metric := customMetricsStoredMetric{
-		Value:  value,
-		Labels: labels,
-		TTL:    time.Now().UTC().Add(15 * time.Minute), // TODO: make TTL configurable
+	previous, ok := sh.custom[key]
+	sh.custom[key] = customMetricsStoredMetric{
+		Value:  value.Custom,
+		Labels: value.Labels,
+		TTL:    ttl,
+		Owners: sh.own(previous.Owners, key, owner),
 	}
 
-	metrics, ok := s.customMetricsStore[value.MetricName]
-	if !ok {
-		s.customMetricsStore[value.MetricName] = map[schema.GroupResource]map[string]map[string]customMetricsStoredMetric{
-			groupResource: map[string]map[string]customMetricsStoredMetric{
-				value.DescribedObject.Namespace: map[string]customMetricsStoredMetric{
-					value.DescribedObject.Name: metric,
-				},
-			},
+	// the labels of the described object may have changed.
+	if !ok || !equalLabels(previous.Labels, value.Labels) {
+		if ok {
+			sh.unindexLabels(scope, key, previous.Labels)
+		}
+		sh.index(scope, key, value.Labels)
+	}
+	return true
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
 		}
-		return
 	}
+	return true
+}
 
-	group, ok := metrics[groupResource]
+// index adds a metric to the scope and label indexes.
+func (sh *metricStoreShard) index(scope scopeKey, key storeKey, metricLabels map[string]string) {
+	keys, ok := sh.scopes[scope]
 	if !ok {
-		metrics[groupResource] = map[string]map[string]customMetricsStoredMetric{
-			value.DescribedObject.Namespace: map[string]customMetricsStoredMetric{
-				value.DescribedObject.Name: metric,
-			},
+		keys = keySet{}
+		sh.scopes[scope] = keys
+
+		if !scope.external {
+			group := groupKey{metricName: scope.metricName, groupResource: scope.groupResource}
+			namespaces, ok := sh.namespaces[group]
+			if !ok {
+				namespaces = map[string]bool{}
+				sh.namespaces[group] = namespaces
+			}
+			namespaces[scope.namespace] = true
+		}
+	}
+	keys[key] = struct{}{}
+
+	for label, value := range metricLabels {
+		labelKey := labelKey{scope: scope, label: label, value: value}
+		keys, ok := sh.labels[labelKey]
+		if !ok {
+			keys = keySet{}
+			sh.labels[labelKey] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (sh *metricStoreShard) unindexLabels(scope scopeKey, key storeKey, metricLabels map[string]string) {
+	for label, value := range metricLabels {
+		labelKey := labelKey{scope: scope, label: label, value: value}
+		keys := sh.labels[labelKey]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(sh.labels, labelKey)
+		}
+	}
+}
+
+// remove removes a metric from the shard, its indexes and its owners.
+func (sh *metricStoreShard) remove(key storeKey) {
+	scope := key.scope()
+
+	var owners map[string]bool
+	if key.external {
+		metric, ok := sh.external[key]
+		if !ok {
+			return
+		}
+		sh.unindexLabels(scope, key, metric.Value.MetricLabels)
+		owners = metric.Owners
+		delete(sh.external, key)
+	} else {
+		metric, ok := sh.custom[key]
+		if !ok {
+			return
 		}
+		sh.unindexLabels(scope, key, metric.Labels)
+		owners = metric.Owners
+		delete(sh.custom, key)
 	}
 
-	namespace, ok := group[value.DescribedObject.Namespace]
+	keys := sh.scopes[scope]
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(sh.scopes, scope)
+
+		if !scope.external {
+			group := groupKey{metricName: scope.metricName, groupResource: scope.groupResource}
+			namespaces := sh.namespaces[group]
+			delete(namespaces, scope.namespace)
+			if len(namespaces) == 0 {
+				delete(sh.namespaces, group)
+			}
+		}
+	}
+
+	for owner := range owners {
+		keys := sh.owned[owner]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(sh.owned, owner)
+		}
+	}
+}
+
+// own adds owner to the owners of the metric stored at key.
+func (sh *metricStoreShard) own(owners map[string]bool, key storeKey, owner string) map[string]bool {
+	if owner == "" {
+		return owners
+	}
+
+	if owners == nil {
+		owners = map[string]bool{}
+	}
+	owners[owner] = true
+
+	keys, ok := sh.owned[owner]
 	if !ok {
-		group[value.DescribedObject.Namespace] = map[string]customMetricsStoredMetric{
-			value.DescribedObject.Name: metric,
+		keys = keySet{}
+		sh.owned[owner] = keys
+	}
+	keys[key] = struct{}{}
+
+	return owners
+}
+
+// disown removes owner from the metrics it owns, except for the metrics in
+// keep. Metrics without other owners are removed.
+func (sh *metricStoreShard) disown(owner string, keep keySet) {
+	for key := range sh.owned[owner] {
+		if _, ok := keep[key]; ok {
+			continue
+		}
+
+		var owners map[string]bool
+		if key.external {
+			owners = sh.external[key].Owners
+		} else {
+			owners = sh.custom[key].Owners
+		}
+
+		delete(sh.owned[owner], key)
+		delete(owners, owner)
+		if len(owners) == 0 {
+			sh.remove(key)
 		}
 	}
 
-	namespace[value.DescribedObject.Name] = metric
+	if len(sh.owned[owner]) == 0 {
+		delete(sh.owned, owner)
+	}
 }
 
-// insertExternalMetric inserts an external metric into the store.
-func (s *MetricStore) insertExternalMetric(metric external_metrics.ExternalMetricValue) {
-	s.Lock()
-	defer s.Unlock()
+// selectKeys returns the metrics of a scope which may match the selector. The
+// smallest set of metrics matching an equality requirement of the selector is
+// looked up in the label index. The metrics must still be matched against the
+// selector.
+func (sh *metricStoreShard) selectKeys(scope scopeKey, selector labels.Selector) keySet {
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return nil
+	}
+
+	candidates := sh.scopes[scope]
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+		default:
+			continue
+		}
 
-	storedMetric := externalMetricsStoredMetric{
-		Value: metric,
-		TTL:   time.Now().UTC().Add(15 * time.Minute), // TODO: make TTL configurable
+		values := requirement.Values()
+		var matches keySet
+		if len(values) == 1 {
+			for value := range values {
+				matches = sh.labels[labelKey{scope: scope, label: requirement.Key(), value: value}]
+			}
+		} else {
+			matches = keySet{}
+			for value := range values {
+				for key := range sh.labels[labelKey{scope: scope, label: requirement.Key(), value: value}] {
+					matches[key] = struct{}{}
+				}
+			}
+		}
+
+		if len(matches) < len(candidates) {
+			candidates = matches
+		}
 	}
 
-	labelsKey := hashLabelMap(metric.MetricLabels)
+	return candidates
+}
 
-	if metrics, ok := s.externalMetricsStore[metric.MetricName]; ok {
-		metrics[labelsKey] = storedMetric
-	} else {
-		s.externalMetricsStore[metric.MetricName] = map[string]externalMetricsStoredMetric{
-			labelsKey: storedMetric,
+// builtinGroupResource returns the group resource of the built-in kinds the
+// adapter collects metrics for, without discovery.
+func builtinGroupResource(kind string) (schema.GroupResource, bool) {
+	switch kind {
+	case "Pod":
+		return schema.GroupResource{
+			Resource: "pods",
+		}, true
+	case "Ingress":
+		return schema.GroupResource{
+			Resource: "ingresses",
+			Group:    "extensions",
+		}, true
+	}
+	return schema.GroupResource{}, false
+}
+
+// groupResource returns the group resource of the described object of a
+// custom metric. Kinds other than the built-in kinds are resolved with the
+// RESTMapper, such that irregular plurals and custom resources get the
+// resource the API server serves them as.
+func (s *MetricStore) groupResource(object custom_metrics.ObjectReference) (schema.GroupResource, error) {
+	if groupResource, ok := builtinGroupResource(object.Kind); ok {
+		return groupResource, nil
+	}
+
+	gv, err := schema.ParseGroupVersion(object.APIVersion)
+	if err != nil {
+		return schema.GroupResource{}, err
+	}
+
+	if s.mapper == nil {
+		return schema.GroupResource{}, fmt.Errorf("no resource mapping for kind %s", gv.WithKind(object.Kind))
+	}
+
+	var versions []string
+	if gv.Version != "" {
+		versions = append(versions, gv.Version)
+	}
+
+	mapping, err := s.mapper.RESTMapping(gv.WithKind(object.Kind).GroupKind(), versions...)
+	if err != nil {
+		return schema.GroupResource{}, err
+	}
+
+	return mapping.Resource.GroupResource(), nil
+}
+
+// expiringMetric is a collected metric together with the time it expires
+// from the store. It's used for copying metrics between stores.
+type expiringMetric struct {
+	Metric  collector.CollectedMetric `json:"metric"`
+	Expires time.Time                 `json:"expires"`
+}
+
+// snapshot returns all metrics in the store which are not expired.
+func (s *MetricStore) snapshot() []expiringMetric {
+	now := time.Now().UTC()
+	var metrics []expiringMetric
+
+	for _, shard := range s.shards {
+		shard.RLock()
+		for _, metric := range shard.custom {
+			if metric.TTL.Before(now) {
+				continue
+			}
+
+			metricType := autoscalingv2beta1.ObjectMetricSourceType
+			if metric.Value.DescribedObject.Kind == "Pod" {
+				metricType = autoscalingv2beta1.PodsMetricSourceType
+			}
+
+			metrics = append(metrics, expiringMetric{
+				Metric: collector.CollectedMetric{
+					Type:   metricType,
+					Custom: metric.Value,
+					Labels: metric.Labels,
+				},
+				Expires: metric.TTL,
+			})
+		}
+
+		for _, metric := range shard.external {
+			if metric.TTL.Before(now) {
+				continue
+			}
+
+			metrics = append(metrics, expiringMetric{
+				Metric: collector.CollectedMetric{
+					Type:     autoscalingv2beta1.ExternalMetricSourceType,
+					External: metric.Value,
+				},
+				Expires: metric.TTL,
+			})
+		}
+		shard.RUnlock()
+	}
+
+	return metrics
+}
+
+// storedMetricValue is the value of a metric in the store.
+type storedMetricValue struct {
+	Value     resource.Quantity
+	Timestamp time.Time
+	Expires   time.Time
+}
+
+// lookup returns the value stored for a collected metric. It returns false if
+// the metric is not in the store, e.g. because it expired.
+func (s *MetricStore) lookup(metric collector.CollectedMetric) (storedMetricValue, bool) {
+	key, ok := s.key(metric)
+	if !ok {
+		return storedMetricValue{}, false
+	}
+
+	shard := s.shard(key.metricName)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	if key.external {
+		stored, ok := shard.external[key]
+		if !ok {
+			return storedMetricValue{}, false
 		}
+
+		return storedMetricValue{
+			Value:     stored.Value.Value,
+			Timestamp: stored.Value.Timestamp.Time,
+			Expires:   stored.TTL,
+		}, true
+	}
+
+	stored, ok := shard.custom[key]
+	if !ok {
+		return storedMetricValue{}, false
 	}
+
+	return storedMetricValue{
+		Value:     stored.Value.Value,
+		Timestamp: stored.Value.Timestamp.Time,
+		Expires:   stored.TTL,
+	}, true
 }
 
 // hashLabelMap converts a map into a sorted string to provide a stable
@@ -143,34 +596,32 @@ func hashLabelMap(labels map[string]string) string {
 	return strings.Join(strLabels, ",")
 }
 
-// GetMetricsBySelector gets metric from the customMetricsStore using a label selector to
+// GetMetricsBySelector gets metric from the store using a label selector to
 // find metrics for matching resources.
 func (s *MetricStore) GetMetricsBySelector(namespace string, selector labels.Selector, info provider.CustomMetricInfo) *custom_metrics.MetricValueList {
 	matchedMetrics := make([]custom_metrics.MetricValue, 0)
 
-	s.RLock()
-	defer s.RUnlock()
+	shard := s.shard(info.Metric)
+	shard.RLock()
+	defer shard.RUnlock()
 
-	metrics, ok := s.customMetricsStore[info.Metric]
-	if !ok {
-		return nil
-	}
-
-	group, ok := metrics[info.GroupResource]
+	namespaces, ok := shard.namespaces[groupKey{metricName: info.Metric, groupResource: info.GroupResource}]
 	if !ok {
 		return nil
 	}
 
+	scopes := []scopeKey{}
 	if !info.Namespaced {
-		for _, metricMap := range group {
-			for _, metric := range metricMap {
-				if selector.Matches(labels.Set(metric.Labels)) {
-					matchedMetrics = append(matchedMetrics, metric.Value)
-				}
-			}
+		for namespace := range namespaces {
+			scopes = append(scopes, scopeKey{metricName: info.Metric, groupResource: info.GroupResource, namespace: namespace})
 		}
-	} else if metricMap, ok := group[namespace]; ok {
-		for _, metric := range metricMap {
+	} else {
+		scopes = append(scopes, scopeKey{metricName: info.Metric, groupResource: info.GroupResource, namespace: namespace})
+	}
+
+	for _, scope := range scopes {
+		for key := range shard.selectKeys(scope, selector) {
+			metric := shard.custom[key]
 			if selector.Matches(labels.Set(metric.Labels)) {
 				matchedMetrics = append(matchedMetrics, metric.Value)
 			}
@@ -180,32 +631,32 @@ func (s *MetricStore) GetMetricsBySelector(namespace string, selector labels.Sel
 	return &custom_metrics.MetricValueList{Items: matchedMetrics}
 }
 
-// GetMetricsByName looks up metrics in the customMetricsStore by resource name.
+// GetMetricsByName looks up metrics in the store by resource name.
 func (s *MetricStore) GetMetricsByName(name types.NamespacedName, info provider.CustomMetricInfo) *custom_metrics.MetricValue {
-	s.RLock()
-	defer s.RUnlock()
-
-	metrics, ok := s.customMetricsStore[info.Metric]
-	if !ok {
-		return nil
-	}
-
-	group, ok := metrics[info.GroupResource]
-	if !ok {
-		return nil
+	shard := s.shard(info.Metric)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	key := storeKey{
+		metricName:    info.Metric,
+		groupResource: info.GroupResource,
+		namespace:     name.Namespace,
+		name:          name.Name,
 	}
 
 	if !info.Namespaced {
 		// TODO: rethink no namespace queries
-		for _, metricMap := range group {
-			if metric, ok := metricMap[name.Name]; ok {
+		for namespace := range shard.namespaces[groupKey{metricName: info.Metric, groupResource: info.GroupResource}] {
+			key.namespace = namespace
+			if metric, ok := shard.custom[key]; ok {
 				return &metric.Value
 			}
 		}
-	} else if metricMap, ok := group[name.Namespace]; ok {
-		if metric, ok := metricMap[name.Name]; ok {
-			return &metric.Value
-		}
+		return nil
+	}
+
+	if metric, ok := shard.custom[key]; ok {
+		return &metric.Value
 	}
 
 	return nil
@@ -213,22 +664,27 @@ func (s *MetricStore) GetMetricsByName(name types.NamespacedName, info provider.
 
 // ListAllMetrics lists all custom metrics in the Metrics Store.
 func (s *MetricStore) ListAllMetrics() []provider.CustomMetricInfo {
-	s.RLock()
-	defer s.RUnlock()
-
-	metrics := make([]provider.CustomMetricInfo, 0, len(s.customMetricsStore))
-
-	for metricName, customMetricsStoredMetrics := range s.customMetricsStore {
-		for groupResource, group := range customMetricsStoredMetrics {
-			for namespace := range group {
-				metric := provider.CustomMetricInfo{
-					GroupResource: groupResource,
-					Namespaced:    namespace != "",
-					Metric:        metricName,
+	metrics := make([]provider.CustomMetricInfo, 0)
+
+	for _, shard := range s.shards {
+		shard.RLock()
+		for group, namespaces := range shard.namespaces {
+			seen := map[bool]bool{}
+			for namespace := range namespaces {
+				namespaced := namespace != ""
+				if seen[namespaced] {
+					continue
 				}
-				metrics = append(metrics, metric)
+				seen[namespaced] = true
+
+				metrics = append(metrics, provider.CustomMetricInfo{
+					GroupResource: group.groupResource,
+					Namespaced:    namespaced,
+					Metric:        group.metricName,
+				})
 			}
 		}
+		shard.RUnlock()
 	}
 
 	return metrics
@@ -239,14 +695,15 @@ func (s *MetricStore) ListAllMetrics() []provider.CustomMetricInfo {
 func (s *MetricStore) GetExternalMetric(namespace string, selector labels.Selector, info provider.ExternalMetricInfo) (*external_metrics.ExternalMetricValueList, error) {
 	matchedMetrics := make([]external_metrics.ExternalMetricValue, 0)
 
-	s.RLock()
-	defer s.RUnlock()
+	shard := s.shard(info.Metric)
+	shard.RLock()
+	defer shard.RUnlock()
 
-	if metrics, ok := s.externalMetricsStore[info.Metric]; ok {
-		for _, metric := range metrics {
-			if selector.Matches(labels.Set(metric.Value.MetricLabels)) {
-				matchedMetrics = append(matchedMetrics, metric.Value)
-			}
+	scope := scopeKey{metricName: info.Metric, external: true}
+	for key := range shard.selectKeys(scope, selector) {
+		metric := shard.external[key]
+		if selector.Matches(labels.Set(metric.Value.MetricLabels)) {
+			matchedMetrics = append(matchedMetrics, metric.Value)
 		}
 	}
 
@@ -255,57 +712,196 @@ func (s *MetricStore) GetExternalMetric(namespace string, selector labels.Select
 
 // ListAllExternalMetrics lists all external metrics in the Metrics Store.
 func (s *MetricStore) ListAllExternalMetrics() []provider.ExternalMetricInfo {
-	s.RLock()
-	defer s.RUnlock()
+	metricsInfo := make([]provider.ExternalMetricInfo, 0)
 
-	metricsInfo := make([]provider.ExternalMetricInfo, 0, len(s.externalMetricsStore))
+	for _, shard := range s.shards {
+		shard.RLock()
+		for scope := range shard.scopes {
+			if !scope.external {
+				continue
+			}
 
-	for metricName := range s.externalMetricsStore {
-		info := provider.ExternalMetricInfo{
-			Metric: metricName,
+			metricsInfo = append(metricsInfo, provider.ExternalMetricInfo{
+				Metric: scope.metricName,
+			})
 		}
-		metricsInfo = append(metricsInfo, info)
+		shard.RUnlock()
 	}
+
 	return metricsInfo
 }
 
+// Purge removes owner from the owners of the metrics it inserted. Metrics
+// without other owners are removed from the store. Later inserts of the owner
+// are ignored. Owners replaced by owner are purged as well, unless they are
+// waiting for other successors.
+func (s *MetricStore) Purge(owner string) {
+	s.replacedLock.Lock()
+	owners := append([]string{owner}, s.succeeded(owner)...)
+	s.replacedLock.Unlock()
+
+	s.purge(owners)
+}
+
+// purge purges the owners from all shards.
+func (s *MetricStore) purge(owners []string) {
+	now := time.Now().UTC()
+	for _, shard := range s.shards {
+		shard.Lock()
+		for _, owner := range owners {
+			shard.purged[owner] = now
+			shard.disown(owner, nil)
+		}
+		shard.Unlock()
+	}
+}
+
+// unpurge allows inserts of a purged owner again, e.g. of a peer which
+// rejoined.
+func (s *MetricStore) unpurge(owner string) {
+	for _, shard := range s.shards {
+		shard.Lock()
+		delete(shard.purged, owner)
+		shard.Unlock()
+	}
+}
+
+// Replace records that owner is replaced by the successors. The metrics of
+// owner are kept until all successors have collected or are purged, and are
+// purged then. Owners pending to be replaced by owner are passed on to the
+// successors. owner is purged right away if there are no successors.
+func (s *MetricStore) Replace(owner string, successors ...string) {
+	if len(successors) == 0 {
+		s.Purge(owner)
+		return
+	}
+
+	s.replacedLock.Lock()
+	defer s.replacedLock.Unlock()
+
+	pending := append([]string{owner}, s.replaced[owner]...)
+	delete(s.replaced, owner)
+
+	for _, replaced := range pending {
+		waiting, ok := s.successors[replaced]
+		if !ok {
+			waiting = map[string]bool{}
+			s.successors[replaced] = waiting
+		}
+		delete(waiting, owner)
+
+		for _, successor := range successors {
+			if !waiting[successor] {
+				waiting[successor] = true
+				s.replaced[successor] = append(s.replaced[successor], replaced)
+			}
+		}
+	}
+}
+
+// Collected purges the owners replaced by owner which are not waiting for
+// other successors. It's called once a collection of owner is inserted.
+func (s *MetricStore) Collected(owner string) {
+	s.replacedLock.Lock()
+	owners := s.succeeded(owner)
+	s.replacedLock.Unlock()
+
+	if len(owners) > 0 {
+		s.purge(owners)
+	}
+}
+
+// succeeded records that successor replaced its owners and returns the
+// owners which are no longer waiting for a successor. The caller must hold
+// the replaced lock.
+func (s *MetricStore) succeeded(successor string) []string {
+	var owners []string
+	for _, owner := range s.replaced[successor] {
+		waiting, ok := s.successors[owner]
+		if !ok {
+			continue
+		}
+
+		delete(waiting, successor)
+		if len(waiting) == 0 {
+			delete(s.successors, owner)
+			owners = append(owners, owner)
+		}
+	}
+	delete(s.replaced, successor)
+	return owners
+}
+
+// keyedMetric is a metric together with its key in the store.
+type keyedMetric struct {
+	key    storeKey
+	metric expiringMetric
+}
+
+// replaceOwned replaces the metrics owned by owner. Metrics previously owned
+// by owner which are not part of the new metrics are disowned.
+func (s *MetricStore) replaceOwned(owner string, metrics []expiringMetric) {
+	now := time.Now().UTC()
+
+	byShard := make(map[*metricStoreShard][]keyedMetric, metricStoreShards)
+	for _, metric := range metrics {
+		if metric.Expires.Before(now) {
+			continue
+		}
+
+		key, ok := s.key(metric.Metric)
+		if !ok {
+			continue
+		}
+
+		shard := s.shard(key.metricName)
+		byShard[shard] = append(byShard[shard], keyedMetric{key: key, metric: metric})
+	}
+
+	// every shard is visited to disown the metrics which are no longer
+	// part of the metrics of the owner.
+	for _, shard := range s.shards {
+		shard.Lock()
+		keep := keySet{}
+		for _, m := range byShard[shard] {
+			if shard.insert(m.key, m.metric.Metric, m.metric.Expires, owner) {
+				keep[m.key] = struct{}{}
+			}
+		}
+		shard.disown(owner, keep)
+		shard.Unlock()
+	}
+}
+
 // RemoveExpired removes expired metrics from the Metrics Store. A metric is
 // considered expired if its TTL is before time.Now().
 func (s *MetricStore) RemoveExpired() {
-	s.Lock()
-	defer s.Unlock()
-
-	// cleanup custom metrics
-	for metricName, groups := range s.customMetricsStore {
-		for group, namespaces := range groups {
-			for namespace, resources := range namespaces {
-				for resource, metric := range resources {
-					if metric.TTL.Before(time.Now().UTC()) {
-						delete(resources, resource)
-					}
-				}
-				if len(resources) == 0 {
-					delete(namespaces, namespace)
-				}
-			}
-			if len(namespaces) == 0 {
-				delete(groups, group)
+	for _, shard := range s.shards {
+		shard.Lock()
+		now := time.Now().UTC()
+
+		// cleanup custom metrics
+		for key, metric := range shard.custom {
+			if metric.TTL.Before(now) {
+				shard.remove(key)
 			}
 		}
-		if len(groups) == 0 {
-			delete(s.customMetricsStore, metricName)
-		}
-	}
 
-	// cleanup external metrics
-	for metricName, metrics := range s.externalMetricsStore {
-		for k, metric := range metrics {
-			if metric.TTL.Before(time.Now().UTC()) {
-				delete(metrics, k)
+		// cleanup external metrics
+		for key, metric := range shard.external {
+			if metric.TTL.Before(now) {
+				shard.remove(key)
 			}
 		}
-		if len(metrics) == 0 {
-			delete(s.externalMetricsStore, metricName)
+
+		// purged owners are kept long enough to ignore inserts of
+		// collections which were in progress when the owner was
+		// purged.
+		for owner, purged := range shard.purged {
+			if purged.Add(metricTTL).Before(now) {
+				delete(shard.purged, owner)
+			}
 		}
+		shard.Unlock()
 	}
 }
@@ -0,0 +1,786 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/custom-metrics-apiserver/pkg/provider"
+	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+var (
+	podsResource    = schema.GroupResource{Resource: "pods"}
+	ingressResource = schema.GroupResource{Group: "extensions", Resource: "ingresses"}
+)
+
+func podMetric(metricName, namespace, name string, value int64, podLabels map[string]string) collector.CollectedMetric {
+	return collector.CollectedMetric{
+		Type: autoscalingv2beta1.PodsMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Namespace:  namespace,
+				Name:       name,
+			},
+			MetricName: metricName,
+			Value:      *resource.NewQuantity(value, resource.DecimalSI),
+		},
+		Labels: podLabels,
+	}
+}
+
+func ingressMetric(metricName, namespace, name string, value int64) collector.CollectedMetric {
+	return collector.CollectedMetric{
+		Type: autoscalingv2beta1.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				APIVersion: "extensions/v1beta1",
+				Kind:       "Ingress",
+				Namespace:  namespace,
+				Name:       name,
+			},
+			MetricName: metricName,
+			Value:      *resource.NewQuantity(value, resource.DecimalSI),
+		},
+	}
+}
+
+func externalMetric(metricName string, value int64, metricLabels map[string]string) collector.CollectedMetric {
+	return collector.CollectedMetric{
+		Type: autoscalingv2beta1.ExternalMetricSourceType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   metricName,
+			MetricLabels: metricLabels,
+			Value:        *resource.NewQuantity(value, resource.DecimalSI),
+		},
+	}
+}
+
+func mustParseSelector(t testing.TB, selector string) labels.Selector {
+	s, err := labels.Parse(selector)
+	if err != nil {
+		t.Fatalf("failed to parse selector '%s': %v", selector, err)
+	}
+	return s
+}
+
+// names returns the sorted namespace/name of the described objects.
+func names(list *custom_metrics.MetricValueList) []string {
+	if list == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.DescribedObject.Namespace+"/"+item.DescribedObject.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMetricStoreInsertLookup(t *testing.T) {
+	store := NewMetricStore(nil)
+	pod := podMetric("requests", "default", "pod-a", 5, map[string]string{"app": "a"})
+	external := externalMetric("queue-length", 7, map[string]string{"queue": "jobs"})
+
+	store.Insert(pod)
+	store.Insert(external)
+
+	stored, ok := store.lookup(pod)
+	if !ok {
+		t.Fatalf("expected pod metric to be stored")
+	}
+	if stored.Value.Value() != 5 {
+		t.Errorf("expected pod metric value 5, got %s", stored.Value.String())
+	}
+
+	stored, ok = store.lookup(external)
+	if !ok {
+		t.Fatalf("expected external metric to be stored")
+	}
+	if stored.Value.Value() != 7 {
+		t.Errorf("expected external metric value 7, got %s", stored.Value.String())
+	}
+
+	// a newer value replaces the previous one.
+	store.Insert(podMetric("requests", "default", "pod-a", 6, map[string]string{"app": "a"}))
+	stored, _ = store.lookup(pod)
+	if stored.Value.Value() != 6 {
+		t.Errorf("expected updated pod metric value 6, got %s", stored.Value.String())
+	}
+
+	metric := store.GetMetricsByName(types.NamespacedName{Namespace: "default", Name: "pod-a"}, provider.CustomMetricInfo{
+		GroupResource: podsResource,
+		Namespaced:    true,
+		Metric:        "requests",
+	})
+	if metric == nil || metric.Value.Value() != 6 {
+		t.Errorf("expected metric of pod-a by name, got %v", metric)
+	}
+
+	metric = store.GetMetricsByName(types.NamespacedName{Name: "pod-a"}, provider.CustomMetricInfo{
+		GroupResource: podsResource,
+		Metric:        "requests",
+	})
+	if metric == nil {
+		t.Errorf("expected metric of pod-a by name in any namespace")
+	}
+
+	metric = store.GetMetricsByName(types.NamespacedName{Namespace: "other", Name: "pod-a"}, provider.CustomMetricInfo{
+		GroupResource: podsResource,
+		Namespaced:    true,
+		Metric:        "requests",
+	})
+	if metric != nil {
+		t.Errorf("expected no metric of pod-a in namespace other, got %v", metric)
+	}
+}
+
+func objectMetric(metricName, apiVersion, kind, namespace, name string, value int64) collector.CollectedMetric {
+	return collector.CollectedMetric{
+		Type: autoscalingv2beta1.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				APIVersion: apiVersion,
+				Kind:       kind,
+				Namespace:  namespace,
+				Name:       name,
+			},
+			MetricName: metricName,
+			Value:      *resource.NewQuantity(value, resource.DecimalSI),
+		},
+	}
+}
+
+// TestMetricStoreObjectResource covers resolving the resource of described
+// objects with irregular plurals and of custom resources with the mapper.
+func TestMetricStoreObjectResource(t *testing.T) {
+	endpoints := schema.GroupVersion{Version: "v1"}
+	policies := schema.GroupVersion{Group: "autoscaling.example.com", Version: "v1alpha1"}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{endpoints, policies})
+	mapper.AddSpecific(endpoints.WithKind("Endpoints"), endpoints.WithResource("endpoints"), endpoints.WithResource("endpoints"), meta.RESTScopeNamespace)
+	mapper.AddSpecific(policies.WithKind("ScalingPolicy"), policies.WithResource("scalingpolicies"), policies.WithResource("scalingpolicy"), meta.RESTScopeNamespace)
+
+	store := NewMetricStore(mapper)
+	store.Insert(objectMetric("requests", "v1", "Endpoints", "default", "svc-a", 3))
+	store.Insert(objectMetric("backlog", "autoscaling.example.com/v1alpha1", "ScalingPolicy", "default", "policy-a", 4))
+	store.Insert(ingressMetric("requests-per-second", "default", "ingress-a", 5))
+
+	for _, tc := range []struct {
+		metric        string
+		groupResource schema.GroupResource
+		name          string
+		value         int64
+	}{
+		{"requests", schema.GroupResource{Resource: "endpoints"}, "svc-a", 3},
+		{"backlog", schema.GroupResource{Group: "autoscaling.example.com", Resource: "scalingpolicies"}, "policy-a", 4},
+		{"requests-per-second", ingressResource, "ingress-a", 5},
+	} {
+		metric := store.GetMetricsByName(types.NamespacedName{Namespace: "default", Name: tc.name}, provider.CustomMetricInfo{
+			GroupResource: tc.groupResource,
+			Namespaced:    true,
+			Metric:        tc.metric,
+		})
+		if metric == nil || metric.Value.Value() != tc.value {
+			t.Errorf("expected metric %s of %s %s with value %d, got %v", tc.metric, tc.groupResource, tc.name, tc.value, metric)
+		}
+	}
+
+	// metrics of unknown kinds can't be queried and are not stored.
+	unknown := objectMetric("requests", "example.com/v1", "Unknown", "default", "unknown-a", 1)
+	store.Insert(unknown)
+	if _, ok := store.lookup(unknown); ok {
+		t.Errorf("expected metric of unknown kind not to be stored")
+	}
+}
+
+// TestMetricStoreNewGroupResourceAndNamespace covers inserting a metric for a
+// group resource and a namespace which are new for an existing metric name,
+// which used to assign to a nil map.
+func TestMetricStoreNewGroupResourceAndNamespace(t *testing.T) {
+	store := NewMetricStore(nil)
+	store.Insert(podMetric("requests", "default", "pod-a", 1, nil))
+	store.Insert(ingressMetric("requests", "default", "ingress-a", 2))
+	store.Insert(podMetric("requests", "other", "pod-b", 3, nil))
+
+	for _, tc := range []struct {
+		info     provider.CustomMetricInfo
+		name     types.NamespacedName
+		expected int64
+	}{
+		{
+			info:     provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "requests"},
+			name:     types.NamespacedName{Namespace: "default", Name: "pod-a"},
+			expected: 1,
+		},
+		{
+			info:     provider.CustomMetricInfo{GroupResource: ingressResource, Namespaced: true, Metric: "requests"},
+			name:     types.NamespacedName{Namespace: "default", Name: "ingress-a"},
+			expected: 2,
+		},
+		{
+			info:     provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "requests"},
+			name:     types.NamespacedName{Namespace: "other", Name: "pod-b"},
+			expected: 3,
+		},
+	} {
+		metric := store.GetMetricsByName(tc.name, tc.info)
+		if metric == nil {
+			t.Errorf("expected metric for %s %s", tc.info.GroupResource, tc.name)
+			continue
+		}
+		if metric.Value.Value() != tc.expected {
+			t.Errorf("expected value %d for %s %s, got %s", tc.expected, tc.info.GroupResource, tc.name, metric.Value.String())
+		}
+	}
+
+	metrics := store.ListAllMetrics()
+	if len(metrics) != 2 {
+		t.Errorf("expected 2 metrics to be listed, got %v", metrics)
+	}
+}
+
+func TestMetricStoreGetMetricsBySelector(t *testing.T) {
+	store := NewMetricStore(nil)
+	store.Insert(podMetric("requests", "default", "a-1", 1, map[string]string{"app": "a", "track": "stable"}))
+	store.Insert(podMetric("requests", "default", "a-2", 1, map[string]string{"app": "a", "track": "canary"}))
+	store.Insert(podMetric("requests", "default", "b-1", 1, map[string]string{"app": "b", "track": "stable"}))
+	store.Insert(podMetric("requests", "default", "c-1", 1, map[string]string{"app": "c"}))
+	store.Insert(podMetric("requests", "other", "a-3", 1, map[string]string{"app": "a", "track": "stable"}))
+	store.Insert(podMetric("latency", "default", "a-1", 1, map[string]string{"app": "a"}))
+
+	info := provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "requests"}
+
+	for _, tc := range []struct {
+		selector   string
+		namespaced bool
+		expected   []string
+	}{
+		{selector: "app=a", namespaced: true, expected: []string{"default/a-1", "default/a-2"}},
+		{selector: "app==a,track=stable", namespaced: true, expected: []string{"default/a-1"}},
+		{selector: "app in (a,b)", namespaced: true, expected: []string{"default/a-1", "default/a-2", "default/b-1"}},
+		{selector: "app in (a,b),track!=canary", namespaced: true, expected: []string{"default/a-1", "default/b-1"}},
+		{selector: "app notin (a)", namespaced: true, expected: []string{"default/b-1", "default/c-1"}},
+		{selector: "track", namespaced: true, expected: []string{"default/a-1", "default/a-2", "default/b-1"}},
+		{selector: "!track", namespaced: true, expected: []string{"default/c-1"}},
+		{selector: "", namespaced: true, expected: []string{"default/a-1", "default/a-2", "default/b-1", "default/c-1"}},
+		{selector: "app=d", namespaced: true, expected: []string{}},
+		{selector: "app=a", namespaced: false, expected: []string{"default/a-1", "default/a-2", "other/a-3"}},
+	} {
+		info.Namespaced = tc.namespaced
+		result := store.GetMetricsBySelector("default", mustParseSelector(t, tc.selector), info)
+		if result == nil {
+			t.Errorf("expected a result for selector '%s'", tc.selector)
+			continue
+		}
+
+		if got := names(result); !equalStrings(got, tc.expected) {
+			t.Errorf("expected %v for selector '%s' (namespaced: %t), got %v", tc.expected, tc.selector, tc.namespaced, got)
+		}
+	}
+
+	// unknown metrics or group resources have no result.
+	if result := store.GetMetricsBySelector("default", labels.Everything(), provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "unknown"}); result != nil {
+		t.Errorf("expected no result for unknown metric, got %v", names(result))
+	}
+	if result := store.GetMetricsBySelector("default", labels.Everything(), provider.CustomMetricInfo{GroupResource: ingressResource, Namespaced: true, Metric: "requests"}); result != nil {
+		t.Errorf("expected no result for unknown group resource, got %v", names(result))
+	}
+}
+
+func TestMetricStoreLabelsChange(t *testing.T) {
+	store := NewMetricStore(nil)
+	store.Insert(podMetric("requests", "default", "pod-a", 1, map[string]string{"app": "a"}))
+	store.Insert(podMetric("requests", "default", "pod-a", 1, map[string]string{"app": "b"}))
+
+	info := provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "requests"}
+
+	if got := names(store.GetMetricsBySelector("default", mustParseSelector(t, "app=a"), info)); len(got) != 0 {
+		t.Errorf("expected no match for previous labels, got %v", got)
+	}
+	if got := names(store.GetMetricsBySelector("default", mustParseSelector(t, "app=b"), info)); !equalStrings(got, []string{"default/pod-a"}) {
+		t.Errorf("expected match for current labels, got %v", got)
+	}
+}
+
+func TestMetricStoreGetExternalMetric(t *testing.T) {
+	store := NewMetricStore(nil)
+	store.Insert(externalMetric("queue-length", 1, map[string]string{"queue": "jobs", "region": "eu"}))
+	store.Insert(externalMetric("queue-length", 2, map[string]string{"queue": "mails", "region": "eu"}))
+	store.Insert(externalMetric("queue-length", 3, map[string]string{"queue": "jobs", "region": "us"}))
+	store.Insert(externalMetric("lag", 4, map[string]string{"queue": "jobs", "region": "eu"}))
+
+	info := provider.ExternalMetricInfo{Metric: "queue-length"}
+
+	for _, tc := range []struct {
+		selector string
+		expected []int64
+	}{
+		{selector: "queue=jobs,region=eu", expected: []int64{1}},
+		{selector: "queue=jobs", expected: []int64{1, 3}},
+		{selector: "region in (eu,us),queue!=jobs", expected: []int64{2}},
+		{selector: "", expected: []int64{1, 2, 3}},
+		{selector: "queue=unknown", expected: []int64{}},
+	} {
+		list, err := store.GetExternalMetric("default", mustParseSelector(t, tc.selector), info)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		values := make([]int64, 0, len(list.Items))
+		for _, item := range list.Items {
+			values = append(values, item.Value.Value())
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+		if fmt.Sprint(values) != fmt.Sprint(tc.expected) {
+			t.Errorf("expected values %v for selector '%s', got %v", tc.expected, tc.selector, values)
+		}
+	}
+
+	metrics := store.ListAllExternalMetrics()
+	if len(metrics) != 2 {
+		t.Errorf("expected 2 external metrics to be listed, got %v", metrics)
+	}
+}
+
+func TestMetricStorePurge(t *testing.T) {
+	store := NewMetricStore(nil)
+	ttl := time.Now().UTC().Add(metricTTL)
+	shared := podMetric("requests", "default", "shared", 1, nil)
+	own := podMetric("requests", "default", "own", 1, nil)
+
+	store.insert(shared, ttl, "collector/1")
+	store.insert(shared, ttl, "collector/2")
+	store.insert(own, ttl, "collector/1")
+
+	store.Purge("collector/1")
+
+	if _, ok := store.lookup(own); ok {
+		t.Errorf("expected metric of purged owner to be removed")
+	}
+	if _, ok := store.lookup(shared); !ok {
+		t.Errorf("expected metric with another owner to be kept")
+	}
+
+	// late inserts of a purged owner are ignored.
+	store.insert(own, ttl, "collector/1")
+	if _, ok := store.lookup(own); ok {
+		t.Errorf("expected insert of purged owner to be ignored")
+	}
+
+	store.Purge("collector/2")
+	if _, ok := store.lookup(shared); ok {
+		t.Errorf("expected metric to be removed once all owners are purged")
+	}
+
+	assertEmptyStore(t, store)
+}
+
+func TestMetricStoreReplace(t *testing.T) {
+	store := NewMetricStore(nil)
+	ttl := time.Now().UTC().Add(metricTTL)
+	metric := podMetric("requests", "default", "pod-a", 1, nil)
+	stale := podMetric("requests", "default", "pod-b", 1, nil)
+
+	store.insert(metric, ttl, "collector/1")
+	store.insert(stale, ttl, "collector/1")
+	store.Replace("collector/1", "collector/2")
+	store.Replace("collector/2", "collector/3")
+
+	if _, ok := store.lookup(metric); !ok {
+		t.Errorf("expected metrics of replaced owner to be kept until the successor collected")
+	}
+
+	store.insert(metric, ttl, "collector/3")
+	store.Collected("collector/3")
+
+	if _, ok := store.lookup(metric); !ok {
+		t.Errorf("expected metric inserted by the successor to be kept")
+	}
+	if _, ok := store.lookup(stale); ok {
+		t.Errorf("expected metric only inserted by the replaced owner to be removed")
+	}
+
+	// purging a successor which never collected purges the replaced
+	// owners as well.
+	store.insert(stale, ttl, "collector/4")
+	store.Replace("collector/4", "collector/5")
+	store.Purge("collector/5")
+	if _, ok := store.lookup(stale); ok {
+		t.Errorf("expected metric of owner replaced by a purged successor to be removed")
+	}
+}
+
+func TestMetricStoreReplaceBySeveral(t *testing.T) {
+	store := NewMetricStore(nil)
+	ttl := time.Now().UTC().Add(metricTTL)
+	collected := podMetric("requests", "default", "pod-a", 1, nil)
+	removed := podMetric("requests", "default", "pod-b", 1, nil)
+
+	store.insert(collected, ttl, storeSnapshotOwner)
+	store.insert(removed, ttl, storeSnapshotOwner)
+	store.Replace(storeSnapshotOwner, "collector/1", "collector/2", "collector/3")
+
+	store.insert(collected, ttl, "collector/1")
+	store.Collected("collector/1")
+	store.Purge("collector/2")
+	if _, ok := store.lookup(removed); !ok {
+		t.Errorf("expected metrics of replaced owner to be kept until all successors collected")
+	}
+
+	store.Collected("collector/3")
+	if _, ok := store.lookup(removed); ok {
+		t.Errorf("expected metric only inserted by the replaced owner to be removed")
+	}
+	if _, ok := store.lookup(collected); !ok {
+		t.Errorf("expected metric inserted by a successor to be kept")
+	}
+
+	// owners are purged right away without successors.
+	store.insert(removed, ttl, "collector/4")
+	store.Replace("collector/4")
+	if _, ok := store.lookup(removed); ok {
+		t.Errorf("expected metric of owner replaced by no successors to be removed")
+	}
+}
+
+func TestMetricStoreUnpurge(t *testing.T) {
+	store := NewMetricStore(nil)
+	expires := time.Now().UTC().Add(metricTTL)
+	metric := podMetric("requests", "default", "pod-a", 1, nil)
+
+	store.replaceOwned("peer/a", []expiringMetric{{Metric: metric, Expires: expires}})
+	store.Purge("peer/a")
+	store.replaceOwned("peer/a", []expiringMetric{{Metric: metric, Expires: expires}})
+	if _, ok := store.lookup(metric); ok {
+		t.Errorf("expected metric of purged owner not to be inserted")
+	}
+
+	store.unpurge("peer/a")
+	store.replaceOwned("peer/a", []expiringMetric{{Metric: metric, Expires: expires}})
+	if _, ok := store.lookup(metric); !ok {
+		t.Errorf("expected metric of unpurged owner to be inserted")
+	}
+}
+
+func TestMetricStoreReplaceOwned(t *testing.T) {
+	store := NewMetricStore(nil)
+	expires := time.Now().UTC().Add(metricTTL)
+	a := podMetric("requests", "default", "pod-a", 1, nil)
+	b := podMetric("requests", "default", "pod-b", 1, nil)
+	c := externalMetric("queue-length", 1, map[string]string{"queue": "jobs"})
+
+	store.replaceOwned("peer/a", []expiringMetric{{Metric: a, Expires: expires}, {Metric: b, Expires: expires}})
+	store.replaceOwned("peer/a", []expiringMetric{
+		{Metric: b, Expires: expires},
+		{Metric: c, Expires: expires},
+		// expired metrics are not inserted.
+		{Metric: podMetric("requests", "default", "pod-c", 1, nil), Expires: time.Now().UTC().Add(-time.Minute)},
+	})
+
+	if _, ok := store.lookup(a); ok {
+		t.Errorf("expected metric no longer owned by the peer to be removed")
+	}
+	if _, ok := store.lookup(b); !ok {
+		t.Errorf("expected metric still owned by the peer to be kept")
+	}
+	if _, ok := store.lookup(c); !ok {
+		t.Errorf("expected new metric of the peer to be inserted")
+	}
+	if _, ok := store.lookup(podMetric("requests", "default", "pod-c", 1, nil)); ok {
+		t.Errorf("expected expired metric not to be inserted")
+	}
+
+	store.replaceOwned("peer/a", nil)
+	assertEmptyStore(t, store)
+}
+
+func TestMetricStoreRemoveExpired(t *testing.T) {
+	store := NewMetricStore(nil)
+	expired := time.Now().UTC().Add(-time.Minute)
+	current := time.Now().UTC().Add(metricTTL)
+
+	store.insert(podMetric("requests", "default", "pod-a", 1, map[string]string{"app": "a"}), expired, "collector/1")
+	store.insert(podMetric("requests", "default", "pod-b", 1, map[string]string{"app": "b"}), current, "collector/1")
+	store.insert(externalMetric("queue-length", 1, map[string]string{"queue": "jobs"}), expired, "")
+
+	store.RemoveExpired()
+
+	info := provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "requests"}
+	if got := names(store.GetMetricsBySelector("default", labels.Everything(), info)); !equalStrings(got, []string{"default/pod-b"}) {
+		t.Errorf("expected only the current metric to be kept, got %v", got)
+	}
+	if metrics := store.ListAllExternalMetrics(); len(metrics) != 0 {
+		t.Errorf("expected expired external metric to be removed, got %v", metrics)
+	}
+
+	store.Purge("collector/1")
+	assertEmptyStore(t, store)
+}
+
+// assertEmptyStore checks that no metrics or index entries are left in the
+// store.
+func assertEmptyStore(t *testing.T, store *MetricStore) {
+	t.Helper()
+
+	for i, shard := range store.shards {
+		shard.RLock()
+		if len(shard.custom) != 0 || len(shard.external) != 0 || len(shard.scopes) != 0 ||
+			len(shard.namespaces) != 0 || len(shard.labels) != 0 || len(shard.owned) != 0 {
+			t.Errorf("expected shard %d to be empty, got %d custom, %d external, %d scopes, %d namespaces, %d labels and %d owners",
+				i, len(shard.custom), len(shard.external), len(shard.scopes), len(shard.namespaces), len(shard.labels), len(shard.owned))
+		}
+		shard.RUnlock()
+	}
+}
+
+// legacyMetricStore is the metric store with nested maps under a single lock
+// which was replaced by MetricStore. It's only used as baseline in the
+// benchmarks.
+type legacyMetricStore struct {
+	customMetricsStore   map[string]map[schema.GroupResource]map[string]map[string]customMetricsStoredMetric
+	externalMetricsStore map[string]map[string]externalMetricsStoredMetric
+	sync.RWMutex
+}
+
+func newLegacyMetricStore() *legacyMetricStore {
+	return &legacyMetricStore{
+		customMetricsStore:   map[string]map[schema.GroupResource]map[string]map[string]customMetricsStoredMetric{},
+		externalMetricsStore: map[string]map[string]externalMetricsStoredMetric{},
+	}
+}
+
+func (s *legacyMetricStore) Insert(value collector.CollectedMetric) {
+	s.Lock()
+	defer s.Unlock()
+
+	ttl := time.Now().UTC().Add(metricTTL)
+	if value.Type == autoscalingv2beta1.ExternalMetricSourceType {
+		metrics, ok := s.externalMetricsStore[value.External.MetricName]
+		if !ok {
+			metrics = map[string]externalMetricsStoredMetric{}
+			s.externalMetricsStore[value.External.MetricName] = metrics
+		}
+		metrics[hashLabelMap(value.External.MetricLabels)] = externalMetricsStoredMetric{Value: value.External, TTL: ttl}
+		return
+	}
+
+	object := value.Custom.DescribedObject
+	groups, ok := s.customMetricsStore[value.Custom.MetricName]
+	if !ok {
+		groups = map[schema.GroupResource]map[string]map[string]customMetricsStoredMetric{}
+		s.customMetricsStore[value.Custom.MetricName] = groups
+	}
+	groupResource, _ := builtinGroupResource(object.Kind)
+	namespaces, ok := groups[groupResource]
+	if !ok {
+		namespaces = map[string]map[string]customMetricsStoredMetric{}
+		groups[groupResource] = namespaces
+	}
+	resources, ok := namespaces[object.Namespace]
+	if !ok {
+		resources = map[string]customMetricsStoredMetric{}
+		namespaces[object.Namespace] = resources
+	}
+	resources[object.Name] = customMetricsStoredMetric{Value: value.Custom, Labels: value.Labels, TTL: ttl}
+}
+
+func (s *legacyMetricStore) GetMetricsBySelector(namespace string, selector labels.Selector, info provider.CustomMetricInfo) *custom_metrics.MetricValueList {
+	s.RLock()
+	defer s.RUnlock()
+
+	matchedMetrics := make([]custom_metrics.MetricValue, 0)
+	for _, metric := range s.customMetricsStore[info.Metric][info.GroupResource][namespace] {
+		if selector.Matches(labels.Set(metric.Labels)) {
+			matchedMetrics = append(matchedMetrics, metric.Value)
+		}
+	}
+	return &custom_metrics.MetricValueList{Items: matchedMetrics}
+}
+
+func (s *legacyMetricStore) GetExternalMetric(namespace string, selector labels.Selector, info provider.ExternalMetricInfo) (*external_metrics.ExternalMetricValueList, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	matchedMetrics := make([]external_metrics.ExternalMetricValue, 0)
+	for _, metric := range s.externalMetricsStore[info.Metric] {
+		if selector.Matches(labels.Set(metric.Value.MetricLabels)) {
+			matchedMetrics = append(matchedMetrics, metric.Value)
+		}
+	}
+	return &external_metrics.ExternalMetricValueList{Items: matchedMetrics}, nil
+}
+
+// benchmarkStore is implemented by MetricStore and legacyMetricStore.
+type benchmarkStore interface {
+	Insert(value collector.CollectedMetric)
+	GetMetricsBySelector(namespace string, selector labels.Selector, info provider.CustomMetricInfo) *custom_metrics.MetricValueList
+	GetExternalMetric(namespace string, selector labels.Selector, info provider.ExternalMetricInfo) (*external_metrics.ExternalMetricValueList, error)
+}
+
+var (
+	benchmarkSeries = []int{10000, 50000}
+	benchmarkStores = []struct {
+		name string
+		new  func() benchmarkStore
+	}{
+		{name: "sharded", new: func() benchmarkStore { return NewMetricStore(nil) }},
+		{name: "legacy", new: func() benchmarkStore { return newLegacyMetricStore() }},
+	}
+)
+
+const (
+	// benchmarkApps is the number of apps, i.e. distinct values of the
+	// app label, the benchmark series are spread over.
+	benchmarkApps = 500
+	// benchmarkMetrics is the number of metric names the benchmark series
+	// are spread over.
+	benchmarkMetrics = 10
+)
+
+// benchmarkPodMetric returns the ith of the benchmark series. The series are
+// pods of benchmarkApps apps in a single namespace, with benchmarkMetrics
+// metrics each.
+func benchmarkPodMetric(i int) collector.CollectedMetric {
+	return podMetric(
+		fmt.Sprintf("metric-%d", i%benchmarkMetrics),
+		"default",
+		fmt.Sprintf("pod-%d", i/benchmarkMetrics),
+		int64(i),
+		map[string]string{"app": fmt.Sprintf("app-%d", (i/benchmarkMetrics)%benchmarkApps)},
+	)
+}
+
+func benchmarkExternalMetric(i int) collector.CollectedMetric {
+	return externalMetric(
+		fmt.Sprintf("metric-%d", i%benchmarkMetrics),
+		int64(i),
+		map[string]string{"queue": fmt.Sprintf("queue-%d", i/benchmarkMetrics), "app": fmt.Sprintf("app-%d", (i/benchmarkMetrics)%benchmarkApps)},
+	)
+}
+
+func populatedStore(newStore func() benchmarkStore, series int) benchmarkStore {
+	store := newStore()
+	for i := 0; i < series; i++ {
+		store.Insert(benchmarkPodMetric(i))
+		store.Insert(benchmarkExternalMetric(i))
+	}
+	return store
+}
+
+func BenchmarkMetricStoreInsert(b *testing.B) {
+	for _, series := range benchmarkSeries {
+		for _, s := range benchmarkStores {
+			b.Run(fmt.Sprintf("%s/series=%d", s.name, series), func(b *testing.B) {
+				store := populatedStore(s.new, series)
+				metrics := make([]collector.CollectedMetric, series)
+				for i := range metrics {
+					metrics[i] = benchmarkPodMetric(i)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					store.Insert(metrics[i%series])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMetricStoreGetMetricsBySelector(b *testing.B) {
+	selector := mustParseSelector(b, "app=app-42")
+	info := provider.CustomMetricInfo{GroupResource: podsResource, Namespaced: true, Metric: "metric-1"}
+
+	for _, series := range benchmarkSeries {
+		for _, s := range benchmarkStores {
+			b.Run(fmt.Sprintf("%s/series=%d", s.name, series), func(b *testing.B) {
+				store := populatedStore(s.new, series)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					store.GetMetricsBySelector("default", selector, info)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMetricStoreGetExternalMetric(b *testing.B) {
+	selector := mustParseSelector(b, "queue=queue-42")
+	info := provider.ExternalMetricInfo{Metric: "metric-2"}
+
+	for _, series := range benchmarkSeries {
+		for _, s := range benchmarkStores {
+			b.Run(fmt.Sprintf("%s/series=%d", s.name, series), func(b *testing.B) {
+				store := populatedStore(s.new, series)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					store.GetExternalMetric("default", selector, info)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkMetricStoreParallel runs selector queries while metrics are
+// inserted concurrently, as the API server does while collectors run.
+func BenchmarkMetricStoreParallel(b *testing.B) {
+	selector := mustParseSelector(b, "app=app-42")
+
+	for _, series := range benchmarkSeries {
+		for _, s := range benchmarkStores {
+			b.Run(fmt.Sprintf("%s/series=%d", s.name, series), func(b *testing.B) {
+				store := populatedStore(s.new, series)
+				metrics := make([]collector.CollectedMetric, series)
+				for i := range metrics {
+					metrics[i] = benchmarkPodMetric(i)
+				}
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						if i%2 == 0 {
+							store.Insert(metrics[i%series])
+						} else {
+							info := provider.CustomMetricInfo{
+								GroupResource: podsResource,
+								Namespaced:    true,
+								Metric:        fmt.Sprintf("metric-%d", i%benchmarkMetrics),
+							}
+							store.GetMetricsBySelector("default", selector, info)
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// peerSyncInterval is the interval at which metrics are fetched from
+	// the peers.
+	peerSyncInterval = 15 * time.Second
+	peerSyncTimeout  = 10 * time.Second
+	peerSyncPath     = "/sync/metrics"
+	// peerSyncOwnerPrefix is the prefix of the owner of metrics synced from
+	// a peer.
+	peerSyncOwnerPrefix = "peer/"
+)
+
+// localMetrics returns the metrics collected by this replica which are still
+// in the store. Metrics synced from peers are not included.
+func (p *HPAProvider) localMetrics() []expiringMetric {
+	scheduler := p.scheduler()
+	if scheduler == nil {
+		return nil
+	}
+
+	var metrics []expiringMetric
+	for _, scheduled := range scheduler.running() {
+		scheduled.state.Lock()
+		values := scheduled.state.LastValues
+		scheduled.state.Unlock()
+
+		for _, value := range values {
+			stored, ok := p.metricStore.lookup(value)
+			if !ok {
+				continue
+			}
+
+			metrics = append(metrics, expiringMetric{
+				Metric:  value,
+				Expires: stored.Expires,
+			})
+		}
+	}
+
+	return metrics
+}
+
+// running returns the running collectors. Collectors shared by several HPAs
+// are returned once.
+func (t *CollectorScheduler) running() []*scheduledCollector {
+	t.RLock()
+	defer t.RUnlock()
+
+	seen := map[*scheduledCollector]bool{}
+	var running []*scheduledCollector
+	for _, collectors := range t.table {
+		for _, scheduled := range collectors {
+			if !seen[scheduled] {
+				seen[scheduled] = true
+				running = append(running, scheduled)
+			}
+		}
+	}
+
+	return running
+}
+
+// PeerHandler returns an HTTP handler serving the metrics collected by this
+// replica on /sync/metrics to the other replicas. Requests must send the token
+// of the coordinator as bearer token, all requests are rejected if it's
+// empty.
+func (p *HPAProvider) PeerHandler() http.Handler {
+	token := p.coordinator.Token()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(peerSyncPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(p.localMetrics())
+		if err != nil {
+			glog.Errorf("Failed to write peer sync response: %v", err)
+		}
+	})
+	return mux
+}
+
+// syncPeers periodically inserts the metrics collected by the peers into the
+// store, such that every replica can serve the metrics of all HPAs. The
+// metrics of peers which are gone, e.g. a replica which was scaled down or a
+// leader which lost the leadership, are purged.
+func (p *HPAProvider) syncPeers(ctx context.Context) {
+	client := &http.Client{Timeout: peerSyncTimeout}
+	last := map[string]bool{}
+
+	for {
+		select {
+		case <-time.After(peerSyncInterval):
+		case <-ctx.Done():
+			glog.Info("Stopped peer sync.")
+			return
+		}
+
+		peers := p.coordinator.Peers()
+		current := make(map[string]bool, len(peers))
+		for _, peer := range peers {
+			current[peer] = true
+			if !last[peer] {
+				// a peer which rejoined may have been purged
+				// before.
+				p.metricStore.unpurge(peerSyncOwnerPrefix + peer)
+			}
+		}
+
+		for peer := range last {
+			if !current[peer] {
+				p.metricStore.Purge(peerSyncOwnerPrefix + peer)
+				glog.Infof("Purged metrics of peer %s", peer)
+			}
+		}
+		last = current
+
+		for _, peer := range peers {
+			metrics, err := fetchPeerMetrics(client, peer, p.coordinator.Token())
+			if err != nil {
+				glog.Errorf("Failed to sync metrics from peer %s: %v", peer, err)
+				continue
+			}
+
+			// metrics which are no longer collected by the peer
+			// are removed right away.
+			p.metricStore.replaceOwned(peerSyncOwnerPrefix+peer, metrics)
+
+			glog.V(1).Infof("Synced %d metric(s) from peer %s", len(metrics), peer)
+		}
+	}
+}
+
+// fetchPeerMetrics gets the metrics collected by a peer. The token is sent
+// as bearer token.
+func fetchPeerMetrics(client *http.Client, peer, token string) ([]expiringMetric, error) {
+	request, err := http.NewRequest(http.MethodGet, "http://"+peer+peerSyncPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var metrics []expiringMetric
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metrics: %v", err)
+	}
+
+	return metrics, nil
+}
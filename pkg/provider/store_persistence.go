@@ -0,0 +1,250 @@
+package provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	storeSnapshotVersion = 1
+	// storeSnapshotInterval is the interval at which snapshots of the
+	// metric store are saved.
+	storeSnapshotInterval = 1 * time.Minute
+	// storeSnapshotConfigMapKey is the key of the gzipped snapshot in the
+	// binary data of the ConfigMap.
+	storeSnapshotConfigMapKey = "snapshot.json.gz"
+	// storeSnapshotConfigMapMaxSize is the maximum size of the data of a
+	// ConfigMap.
+	storeSnapshotConfigMapMaxSize = 1 << 20
+	// storeSnapshotOwner owns the restored metrics in the metric store.
+	storeSnapshotOwner = "snapshot"
+)
+
+// StorePersistence saves and loads snapshots of the metric store, such that
+// the metrics are available right after a restart of the adapter.
+type StorePersistence interface {
+	// Save saves a snapshot, replacing the previous one.
+	Save(snapshot []byte) error
+	// Load loads the last snapshot. It returns nil if there is none.
+	Load() ([]byte, error)
+}
+
+// storeSnapshot is the persisted format of the metric store.
+type storeSnapshot struct {
+	Version int              `json:"version"`
+	Metrics []expiringMetric `json:"metrics"`
+}
+
+// saveStore saves a snapshot of the metric store.
+func (p *HPAProvider) saveStore() error {
+	data, err := json.Marshal(storeSnapshot{
+		Version: storeSnapshotVersion,
+		Metrics: p.metricStore.snapshot(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.persistence.Save(data)
+}
+
+// restoreStore inserts the metrics of the last snapshot which are not
+// expired into the metric store. The timestamps and expiry of the metrics are
+// kept. The metrics are owned by storeSnapshotOwner.
+func (p *HPAProvider) restoreStore() error {
+	data, err := p.persistence.Load()
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		glog.Info("No metric store snapshot to restore")
+		return nil
+	}
+
+	var snapshot storeSnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to decode metric store snapshot: %v", err)
+	}
+
+	if snapshot.Version != storeSnapshotVersion {
+		return fmt.Errorf("unsupported metric store snapshot version %d", snapshot.Version)
+	}
+
+	now := time.Now().UTC()
+	restored := 0
+	for _, metric := range snapshot.Metrics {
+		if metric.Expires.Before(now) {
+			continue
+		}
+		p.metricStore.insert(metric.Metric, metric.Expires, storeSnapshotOwner)
+		restored++
+	}
+
+	glog.Infof("Restored %d of %d metric(s) from the metric store snapshot", restored, len(snapshot.Metrics))
+	return nil
+}
+
+// persistStore periodically saves snapshots of the metric store. A last
+// snapshot is saved when the context is canceled.
+func (p *HPAProvider) persistStore(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(storeSnapshotInterval):
+		case <-ctx.Done():
+			err := p.saveStore()
+			if err != nil {
+				glog.Errorf("Failed to save metric store snapshot: %v", err)
+			}
+			glog.Info("Stopped metric store persistence.")
+			return
+		}
+
+		err := p.saveStore()
+		if err != nil {
+			glog.Errorf("Failed to save metric store snapshot: %v", err)
+		}
+	}
+}
+
+// filePersistence saves snapshots of the metric store to a file, e.g. on a
+// persistent volume.
+type filePersistence struct {
+	path string
+}
+
+// NewFilePersistence initializes a StorePersistence saving snapshots to the
+// file at path.
+func NewFilePersistence(path string) StorePersistence {
+	return &filePersistence{path: path}
+}
+
+// Save writes the snapshot to a temporary file which then replaces the
+// previous snapshot, such that a partially written snapshot is never loaded.
+func (f *filePersistence) Save(snapshot []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(snapshot)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *filePersistence) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// configMapPersistence saves gzipped snapshots of the metric store to a
+// ConfigMap. Snapshots are limited by the maximum size of a ConfigMap (1MiB).
+type configMapPersistence struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapPersistence initializes a StorePersistence saving snapshots to
+// a ConfigMap.
+func NewConfigMapPersistence(client kubernetes.Interface, namespace, name string) StorePersistence {
+	return &configMapPersistence{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Save saves the snapshot, creating the ConfigMap if it doesn't exist.
+func (c *configMapPersistence) Save(snapshot []byte) error {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	if buf.Len() > storeSnapshotConfigMapMaxSize {
+		return fmt.Errorf("gzipped snapshot of %d bytes exceeds the maximum size of ConfigMap %s/%s (1MiB), save snapshots to a file instead", buf.Len(), c.namespace, c.name)
+	}
+
+	configMaps := c.client.CoreV1().ConfigMaps(c.namespace)
+
+	configMap, err := configMaps.Get(c.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.name,
+				Namespace: c.namespace,
+			},
+			BinaryData: map[string][]byte{
+				storeSnapshotConfigMapKey: buf.Bytes(),
+			},
+		}
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	configMap.BinaryData = map[string][]byte{
+		storeSnapshotConfigMapKey: buf.Bytes(),
+	}
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+func (c *configMapPersistence) Load() ([]byte, error) {
+	configMap, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(c.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := configMap.BinaryData[storeSnapshotConfigMapKey]
+	if !ok {
+		return nil, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot: %v", err)
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
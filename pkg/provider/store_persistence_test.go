@@ -0,0 +1,225 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// memoryPersistence keeps the snapshot in memory.
+type memoryPersistence struct {
+	snapshot []byte
+}
+
+func (m *memoryPersistence) Save(snapshot []byte) error {
+	m.snapshot = snapshot
+	return nil
+}
+
+func (m *memoryPersistence) Load() ([]byte, error) {
+	return m.snapshot, nil
+}
+
+func TestStorePersistenceRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		persistence func(t *testing.T) StorePersistence
+	}{
+		{
+			name: "file",
+			persistence: func(t *testing.T) StorePersistence {
+				return NewFilePersistence(filepath.Join(t.TempDir(), "metrics.json"))
+			},
+		},
+		{
+			name: "configmap",
+			persistence: func(t *testing.T) StorePersistence {
+				return NewConfigMapPersistence(fake.NewSimpleClientset(), "kube-system", "snapshot")
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			persistence := tc.persistence(t)
+
+			err := (&HPAProvider{metricStore: NewMetricStore(nil), persistence: persistence}).restoreStore()
+			if err != nil {
+				t.Fatalf("expected no error without snapshot, got %v", err)
+			}
+
+			expires := time.Now().UTC().Add(metricTTL).Truncate(time.Second)
+			external := externalMetric("queue-length", 10, map[string]string{"queue": "jobs"})
+			pod := podMetric("requests", "default", "pod-a", 5, map[string]string{"app": "a"})
+			expired := podMetric("requests", "default", "pod-b", 1, nil)
+
+			saved := &HPAProvider{metricStore: NewMetricStore(nil), persistence: persistence}
+			saved.metricStore.insert(external, expires, "collector/1")
+			saved.metricStore.insert(pod, expires, "collector/2")
+			saved.metricStore.insert(expired, time.Now().UTC().Add(-time.Second), "collector/2")
+
+			err = saved.saveStore()
+			if err != nil {
+				t.Fatalf("failed to save snapshot: %v", err)
+			}
+
+			restored := &HPAProvider{metricStore: NewMetricStore(nil), persistence: persistence}
+			err = restored.restoreStore()
+			if err != nil {
+				t.Fatalf("failed to restore snapshot: %v", err)
+			}
+
+			for _, metric := range []struct {
+				collected collector.CollectedMetric
+				value     int64
+			}{
+				{collected: external, value: 10},
+				{collected: pod, value: 5},
+			} {
+				stored, ok := restored.metricStore.lookup(metric.collected)
+				if !ok {
+					t.Fatalf("expected restored metric with value %d", metric.value)
+				}
+				if stored.Value.Value() != metric.value {
+					t.Errorf("expected value %d, got %s", metric.value, stored.Value.String())
+				}
+				if !stored.Expires.Equal(expires) {
+					t.Errorf("expected expiry %s to be kept, got %s", expires, stored.Expires)
+				}
+			}
+
+			if _, ok := restored.metricStore.lookup(expired); ok {
+				t.Error("expected expired metric not to be restored")
+			}
+		})
+	}
+}
+
+func TestConfigMapPersistenceGzip(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	persistence := NewConfigMapPersistence(client, "kube-system", "snapshot")
+
+	snapshot := bytes.Repeat([]byte(`{"version":1,"metrics":[]}`), 1000)
+	err := persistence.Save(snapshot)
+	if err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps("kube-system").Get("snapshot", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get configmap: %v", err)
+	}
+
+	data := configMap.BinaryData[storeSnapshotConfigMapKey]
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Error("expected gzipped snapshot")
+	}
+	if len(data) >= len(snapshot) {
+		t.Errorf("expected compressed snapshot, got %d of %d bytes", len(data), len(snapshot))
+	}
+
+	// a second save updates the existing ConfigMap.
+	err = persistence.Save([]byte(`{"version":1}`))
+	if err != nil {
+		t.Fatalf("failed to update snapshot: %v", err)
+	}
+
+	loaded, err := persistence.Load()
+	if err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if string(loaded) != `{"version":1}` {
+		t.Errorf("expected updated snapshot, got %s", loaded)
+	}
+}
+
+func TestConfigMapPersistenceSizeLimit(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	persistence := NewConfigMapPersistence(client, "kube-system", "snapshot")
+
+	// random data can't be compressed below the limit.
+	snapshot := make([]byte, storeSnapshotConfigMapMaxSize+1024)
+	_, err := rand.Read(snapshot)
+	if err != nil {
+		t.Fatalf("failed to generate snapshot: %v", err)
+	}
+
+	err = persistence.Save(snapshot)
+	if err == nil || !strings.Contains(err.Error(), "1MiB") {
+		t.Fatalf("expected error for snapshot exceeding the size limit, got %v", err)
+	}
+
+	_, err = client.CoreV1().ConfigMaps("kube-system").Get("snapshot", metav1.GetOptions{})
+	if err == nil {
+		t.Error("expected no configmap to be created")
+	}
+}
+
+func TestRestoreStoreInvalidSnapshot(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		snapshot string
+	}{
+		{name: "unsupported version", snapshot: `{"version":2,"metrics":[]}`},
+		{name: "missing version", snapshot: `{"metrics":[]}`},
+		{name: "invalid json", snapshot: `{"version":`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &HPAProvider{
+				metricStore: NewMetricStore(nil),
+				persistence: &memoryPersistence{snapshot: []byte(tc.snapshot)},
+			}
+
+			err := p.restoreStore()
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRestoredMetricsReplacedByCollection(t *testing.T) {
+	expires := time.Now().UTC().Add(metricTTL)
+	collected := externalMetric("queue-length", 10, map[string]string{"queue": "jobs"})
+	removed := externalMetric("queue-length", 3, map[string]string{"queue": "removed"})
+
+	persistence := &memoryPersistence{}
+	saved := &HPAProvider{metricStore: NewMetricStore(nil), persistence: persistence}
+	saved.metricStore.insert(collected, expires, "collector/1")
+	saved.metricStore.insert(removed, expires, "collector/2")
+	err := saved.saveStore()
+	if err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	p := &HPAProvider{metricStore: NewMetricStore(nil), persistence: persistence}
+	err = p.restoreStore()
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+
+	// the restored metrics are replaced by the collectors of the HPAs
+	// after the first update, as in HPAProvider.Run.
+	p.metricStore.Replace(storeSnapshotOwner, "collector/1")
+
+	update := externalMetric("queue-length", 20, map[string]string{"queue": "jobs"})
+	p.metricStore.insert(update, time.Now().UTC().Add(metricTTL), "collector/1")
+	p.metricStore.Collected("collector/1")
+
+	stored, ok := p.metricStore.lookup(update)
+	if !ok {
+		t.Fatal("expected collected metric")
+	}
+	if stored.Value.Value() != 20 {
+		t.Errorf("expected restored value to be overwritten by the collection, got %s", stored.Value.String())
+	}
+
+	if _, ok := p.metricStore.lookup(removed); ok {
+		t.Error("expected restored metric without collector to be removed after the first collection")
+	}
+}
@@ -0,0 +1,219 @@
+package provider
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// collectorQueue is a priority queue of scheduled collectors ordered by the
+// time of their next run. It implements heap.Interface.
+type collectorQueue []*scheduledCollector
+
+func (q collectorQueue) Len() int { return len(q) }
+
+func (q collectorQueue) Less(i, j int) bool {
+	return q[i].next.Before(q[j].next)
+}
+
+func (q collectorQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *collectorQueue) Push(x interface{}) {
+	scheduled := x.(*scheduledCollector)
+	scheduled.index = len(*q)
+	*q = append(*q, scheduled)
+}
+
+func (q *collectorQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	scheduled := old[n-1]
+	old[n-1] = nil
+	scheduled.index = -1
+	*q = old[:n-1]
+	return scheduled
+}
+
+// workerPool runs scheduled collectors with a fixed number of workers. A
+// single dispatcher hands collectors to the workers once their next run is
+// due, such that the number of goroutines doesn't grow with the number of
+// collectors.
+type workerPool struct {
+	workers    int
+	queue      collectorQueue
+	wakeup     chan struct{}
+	work       chan *scheduledCollector
+	metricSink *metricQueue
+	sync.Mutex
+}
+
+func newWorkerPool(workers int, metricSink *metricQueue) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &workerPool{
+		workers:    workers,
+		wakeup:     make(chan struct{}, 1),
+		work:       make(chan *scheduledCollector),
+		metricSink: metricSink,
+	}
+}
+
+// Run starts the dispatcher and the workers. They are stopped when the
+// context is canceled.
+func (p *workerPool) Run(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		go p.worker(ctx)
+	}
+	go p.dispatch(ctx)
+}
+
+// schedule queues the next run of a collector. Stopped collectors are not
+// queued.
+func (p *workerPool) schedule(scheduled *scheduledCollector, wait time.Duration) {
+	p.Lock()
+	if scheduled.stopped {
+		p.Unlock()
+		return
+	}
+	scheduled.next = time.Now().Add(wait)
+	heap.Push(&p.queue, scheduled)
+	p.Unlock()
+
+	scheduled.state.scheduleNext(wait)
+
+	// wake up the dispatcher in case the collector is due before the
+	// collector it's waiting for.
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// stop removes a collector from the queue. A run which is already in progress
+// finishes, but the collector is not queued again.
+func (p *workerPool) stop(scheduled *scheduledCollector) {
+	p.Lock()
+	defer p.Unlock()
+
+	scheduled.stopped = true
+	if scheduled.index >= 0 {
+		heap.Remove(&p.queue, scheduled.index)
+	}
+}
+
+func (p *workerPool) stopped(scheduled *scheduledCollector) bool {
+	p.Lock()
+	defer p.Unlock()
+	return scheduled.stopped
+}
+
+// dispatch hands collectors which are due to the workers, earliest first.
+func (p *workerPool) dispatch(ctx context.Context) {
+	for {
+		var wait <-chan time.Time
+		var timer *time.Timer
+
+		p.Lock()
+		if len(p.queue) > 0 {
+			next := p.queue[0]
+			delay := time.Until(next.next)
+			if delay <= 0 {
+				heap.Pop(&p.queue)
+				p.Unlock()
+
+				select {
+				case p.work <- next:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			timer = time.NewTimer(delay)
+			wait = timer.C
+		}
+		p.Unlock()
+
+		select {
+		case <-wait:
+		case <-p.wakeup:
+		case <-ctx.Done():
+			glog.V(2).Infof("stopping collector dispatcher...")
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (p *workerPool) worker(ctx context.Context) {
+	for {
+		select {
+		case scheduled := <-p.work:
+			if !p.stopped(scheduled) {
+				p.run(scheduled)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs a collector once and schedules its next run. The interval is
+// jittered and backs off exponentially on consecutive failures. Collectors of
+// a backend which reached its limits or has an open circuit breaker are
+// rescheduled without running. Results are pushed to the metric queue without
+// blocking.
+func (p *workerPool) run(scheduled *scheduledCollector) {
+	metricCollector := scheduled.collector
+	breaker := scheduled.breaker
+	interval := metricCollector.Interval()
+
+	// the limiter is acquired before asking the breaker, since a breaker
+	// which allows a trial expects the result.
+	release := func() {}
+	if scheduled.limiter != nil {
+		var wait time.Duration
+		release, wait = scheduled.limiter.acquire()
+		if release == nil {
+			glog.V(2).Infof("Delaying %T, limit of backend %s reached", metricCollector, scheduled.limiter.backend)
+			p.schedule(scheduled, wait)
+			return
+		}
+	}
+
+	if breaker != nil && !breaker.Allow() {
+		release()
+		glog.V(2).Infof("Skipping %T, circuit breaker for backend %s is %s", metricCollector, breaker.backend, breaker.State())
+		p.schedule(scheduled, jitter(interval))
+		return
+	}
+
+	start := time.Now()
+	values, err := metricCollector.GetMetrics()
+	release()
+	if breaker != nil {
+		breaker.Done(err)
+	}
+
+	failures := scheduled.state.record(start, values, err)
+
+	// never block the collector on a slow consumer, drop the
+	// collection instead.
+	if !p.metricSink.Push(metricCollection{Values: values, Error: err, Owner: scheduled.owner}) {
+		glog.Warningf("Dropped metrics collected by %T, metric queue is full", metricCollector)
+	}
+
+	p.schedule(scheduled, nextRun(interval, failures))
+}
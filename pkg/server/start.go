@@ -20,10 +20,17 @@ import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/custom-metrics-apiserver/pkg/cmd/server"
+	"github.com/kubernetes-incubator/custom-metrics-apiserver/pkg/dynamicmapper"
 	"github.com/mikkeloscar/kube-metrics-adapter/pkg/collector"
 	"github.com/mikkeloscar/kube-metrics-adapter/pkg/provider"
 	"github.com/spf13/cobra"
@@ -44,6 +51,15 @@ func NewCommandStartAdapterServer(stopCh <-chan struct{}) *cobra.Command {
 		CustomMetricsAdapterServerOptions: baseOpts,
 		EnableCustomMetricsAPI:            true,
 		EnableExternalMetricsAPI:          true,
+		CollectorWorkers:                  20,
+		BackendConcurrency:                5,
+		CircuitBreakerThreshold:           5,
+		CircuitBreakerTimeout:             time.Minute,
+		CoordinationNamespace:             "kube-system",
+		CoordinationName:                  "kube-metrics-adapter",
+		CoordinationAddress:               ":9096",
+		PodIP:                             os.Getenv("POD_IP"),
+		DiscoveryInterval:                 10 * time.Minute,
 	}
 
 	cmd := &cobra.Command{
@@ -72,17 +88,123 @@ func NewCommandStartAdapterServer(stopCh <-chan struct{}) *cobra.Command {
 	flags.StringVar(&o.RemoteKubeConfigFile, "lister-kubeconfig", o.RemoteKubeConfigFile, ""+
 		"kubeconfig file pointing at the 'core' kubernetes server with enough rights to list "+
 		"any described objects")
+	flags.DurationVar(&o.DiscoveryInterval, "discovery-interval", o.DiscoveryInterval, ""+
+		"interval at which to refresh the API discovery information used to resolve the resources of described objects")
 	flags.BoolVar(&o.EnableCustomMetricsAPI, "enable-custom-metrics-api", o.EnableCustomMetricsAPI, ""+
 		"whether to enable Custom Metrics API")
 	flags.BoolVar(&o.EnableExternalMetricsAPI, "enable-external-metrics-api", o.EnableExternalMetricsAPI, ""+
 		"whether to enable External Metrics API")
+	flags.IntVar(&o.CollectorWorkers, "collector-workers", o.CollectorWorkers, ""+
+		"number of collectors running at the same time")
+	flags.IntVar(&o.BackendConcurrency, "backend-concurrency", o.BackendConcurrency, ""+
+		"maximum number of collectors running at the same time against the same backend, e.g. a Prometheus server or AWS region. 0 means no limit")
+	flags.Float64Var(&o.BackendRateLimit, "backend-rate-limit", o.BackendRateLimit, ""+
+		"maximum number of collector runs per second against the same backend. 0 means no limit")
+	flags.IntVar(&o.CircuitBreakerThreshold, "circuit-breaker-threshold", o.CircuitBreakerThreshold, ""+
+		"number of consecutive failures of a backend after which its collectors are paused. 0 disables the circuit breakers")
+	flags.DurationVar(&o.CircuitBreakerTimeout, "circuit-breaker-timeout", o.CircuitBreakerTimeout, ""+
+		"time the collectors of a failing backend are paused before a single collector tries the backend again")
+	flags.StringVar(&o.CoordinationMode, "coordination-mode", o.CoordinationMode, ""+
+		"how replicas of the adapter split the collection: 'leader' for a single elected replica collecting all metrics, "+
+		"'sharded' for splitting the HPAs between the replicas. Every replica collects all metrics if empty")
+	flags.StringVar(&o.CoordinationNamespace, "coordination-namespace", o.CoordinationNamespace, ""+
+		"namespace of the leader election Lease and the adapter service")
+	flags.StringVar(&o.CoordinationName, "coordination-name", o.CoordinationName, ""+
+		"name of the leader election Lease in leader mode and of the service selecting the adapter replicas in sharded mode")
+	flags.StringVar(&o.CoordinationAddress, "coordination-address", o.CoordinationAddress, ""+
+		"address to serve collected metrics to the other replicas on. The host defaults to the pod IP")
+	flags.StringVar(&o.CoordinationTokenFile, "coordination-token-file", o.CoordinationTokenFile, ""+
+		"file containing a token the replicas authenticate to each other with, e.g. mounted from a Secret. "+
+		"Required if a coordination mode is set")
+	flags.StringVar(&o.PodIP, "pod-ip", o.PodIP, ""+
+		"IP of the adapter pod the other replicas get metrics from. Defaults to the POD_IP environment variable")
+	flags.StringVar(&o.StoreSnapshotFile, "store-snapshot-file", o.StoreSnapshotFile, ""+
+		"file, e.g. on a persistent volume, to save snapshots of the metric store to and restore them from on startup")
+	flags.StringVar(&o.StoreSnapshotConfigMap, "store-snapshot-configmap", o.StoreSnapshotConfigMap, ""+
+		"ConfigMap in the format <namespace>/<name> to save snapshots of the metric store to and restore them from on startup")
+	flags.StringVar(&o.DebugAddress, "debug-address", o.DebugAddress, ""+
+		"address to serve the unauthenticated read-only debug API on, e.g. :9095. Without a host the API is served on localhost only. The debug API is disabled if empty")
 	flags.StringVar(&o.PrometheusServer, "prometheus-server", o.PrometheusServer, ""+
 		"url of prometheus server to query")
+	flags.StringVar(&o.PrometheusCAFile, "prometheus-ca-file", o.PrometheusCAFile, ""+
+		"CA bundle used to verify the certificate of the prometheus server")
+	flags.StringVar(&o.PrometheusCertFile, "prometheus-cert-file", o.PrometheusCertFile, ""+
+		"client certificate used to authenticate to the prometheus server")
+	flags.StringVar(&o.PrometheusKeyFile, "prometheus-key-file", o.PrometheusKeyFile, ""+
+		"client key used to authenticate to the prometheus server")
+	flags.BoolVar(&o.PrometheusInsecureSkipVerify, "prometheus-insecure-skip-verify", o.PrometheusInsecureSkipVerify, ""+
+		"whether to skip verification of the prometheus server certificate")
+	flags.StringVar(&o.PrometheusBearerTokenFile, "prometheus-bearer-token-file", o.PrometheusBearerTokenFile, ""+
+		"file containing a bearer token for the prometheus server. The file is read again when it changes")
+	flags.StringVar(&o.PrometheusUsername, "prometheus-username", o.PrometheusUsername, ""+
+		"username for basic auth to the prometheus server")
+	flags.StringVar(&o.PrometheusPasswordFile, "prometheus-password-file", o.PrometheusPasswordFile, ""+
+		"file containing the password for basic auth to the prometheus server")
+	flags.StringArrayVar(&o.PrometheusHeaders, "prometheus-header", o.PrometheusHeaders, ""+
+		"extra header to send to the prometheus server in the format <name>=<value>. Can be specified multiple times")
+	flags.StringSliceVar(&o.JSONPathAllowedHosts, "json-path-allowed-hosts", o.JSONPathAllowedHosts, ""+
+		"hosts which json-path object metrics may request with an explicit url")
+	flags.StringVar(&o.InfluxDBServer, "influxdb-server", o.InfluxDBServer, ""+
+		"default url of the InfluxDB server used if an HPA doesn't define one")
+	flags.StringVar(&o.GraphiteServer, "graphite-server", o.GraphiteServer, ""+
+		"default url of the Graphite server used if an HPA doesn't define one")
+	flags.StringVar(&o.ElasticsearchServer, "elasticsearch-server", o.ElasticsearchServer, ""+
+		"default url of the Elasticsearch server used if an HPA doesn't define one")
+	flags.StringSliceVar(&o.InfluxDBAllowedHosts, "influxdb-allowed-hosts", o.InfluxDBAllowedHosts, ""+
+		"hosts of InfluxDB servers which HPAs may define in addition to the default server")
+	flags.StringSliceVar(&o.GraphiteAllowedHosts, "graphite-allowed-hosts", o.GraphiteAllowedHosts, ""+
+		"hosts of Graphite servers which HPAs may define in addition to the default server")
+	flags.StringSliceVar(&o.ElasticsearchAllowedHosts, "elasticsearch-allowed-hosts", o.ElasticsearchAllowedHosts, ""+
+		"hosts of Elasticsearch servers which HPAs may define in addition to the default server")
 	flags.BoolVar(&o.SkipperIngressMetrics, "skipper-ingress-metrics", o.SkipperIngressMetrics, ""+
 		"whether to enable skipper ingress metrics")
 	flags.BoolVar(&o.AWSExternalMetrics, "aws-external-metrics", o.AWSExternalMetrics, ""+
 		"whether to enable AWS external metrics")
 	flags.StringSliceVar(&o.AWSRegions, "aws-region", o.AWSRegions, "the AWS regions which should be monitored. eg: eu-central, eu-west-1")
+	flags.BoolVar(&o.HTTPJSONExternalMetrics, "http-json-external-metrics", o.HTTPJSONExternalMetrics, ""+
+		"whether to enable HTTP JSON external metrics")
+	flags.StringSliceVar(&o.HTTPJSONAllowedHosts, "http-json-allowed-hosts", o.HTTPJSONAllowedHosts, ""+
+		"hosts which HTTP JSON external metrics may request")
+	flags.BoolVar(&o.RabbitMQExternalMetrics, "rabbitmq-external-metrics", o.RabbitMQExternalMetrics, ""+
+		"whether to enable RabbitMQ external metrics")
+	flags.StringVar(&o.RabbitMQURL, "rabbitmq-url", o.RabbitMQURL, ""+
+		"default url of the RabbitMQ management API used if an HPA doesn't define one")
+	flags.BoolVar(&o.RedisExternalMetrics, "redis-external-metrics", o.RedisExternalMetrics, ""+
+		"whether to enable Redis external metrics")
+	flags.StringVar(&o.RedisAddress, "redis-address", o.RedisAddress, ""+
+		"default address of the Redis server used if an HPA doesn't define one")
+	flags.BoolVar(&o.NATSExternalMetrics, "nats-external-metrics", o.NATSExternalMetrics, ""+
+		"whether to enable NATS JetStream external metrics")
+	flags.StringVar(&o.NATSServer, "nats-server", o.NATSServer, ""+
+		"default url of the NATS server used if an HPA doesn't define one")
+	flags.StringVar(&o.NATSTokenFile, "nats-token-file", o.NATSTokenFile, ""+
+		"file containing the default token used to authenticate to the NATS server")
+	flags.StringVar(&o.NATSUsername, "nats-username", o.NATSUsername, ""+
+		"default username used to authenticate to the NATS server")
+	flags.StringVar(&o.NATSPasswordFile, "nats-password-file", o.NATSPasswordFile, ""+
+		"file containing the default password used to authenticate to the NATS server")
+	flags.StringVar(&o.SQLDatasourcesFile, "sql-datasources-file", o.SQLDatasourcesFile, ""+
+		"file defining the datasources which can be queried by SQL external metrics. SQL external metrics are enabled if set")
+	flags.BoolVar(&o.KafkaExternalMetrics, "kafka-external-metrics", o.KafkaExternalMetrics, ""+
+		"whether to enable Kafka external metrics")
+	flags.StringSliceVar(&o.KafkaBrokers, "kafka-brokers", o.KafkaBrokers, ""+
+		"default Kafka brokers used if an HPA doesn't define brokers")
+	flags.StringVar(&o.KafkaVersion, "kafka-version", o.KafkaVersion, ""+
+		"Kafka version of the brokers e.g. 1.0.0")
+	flags.BoolVar(&o.KafkaTLS, "kafka-tls", o.KafkaTLS, ""+
+		"whether to use TLS for connections to the Kafka brokers")
+	flags.StringVar(&o.KafkaCAFile, "kafka-ca-file", o.KafkaCAFile, ""+
+		"CA bundle used to verify the certificates of the Kafka brokers")
+	flags.StringVar(&o.KafkaCertFile, "kafka-cert-file", o.KafkaCertFile, ""+
+		"client certificate used to authenticate to the Kafka brokers")
+	flags.StringVar(&o.KafkaKeyFile, "kafka-key-file", o.KafkaKeyFile, ""+
+		"client key used to authenticate to the Kafka brokers")
+	flags.BoolVar(&o.KafkaInsecureSkipVerify, "kafka-insecure-skip-verify", o.KafkaInsecureSkipVerify, ""+
+		"whether to skip verification of the Kafka broker certificates")
+	flags.StringVar(&o.KafkaSASLUsername, "kafka-sasl-username", o.KafkaSASLUsername, ""+
+		"username for SASL/PLAIN authentication to the Kafka brokers")
+	flags.StringVar(&o.KafkaSASLPasswordFile, "kafka-sasl-password-file", o.KafkaSASLPasswordFile, ""+
+		"file containing the password for SASL/PLAIN authentication to the Kafka brokers")
 
 	return cmd
 }
@@ -116,7 +238,12 @@ func (o AdapterServerOptions) RunCustomMetricsAdapterServer(stopCh <-chan struct
 	collectorFactory := collector.NewCollectorFactory()
 
 	if o.PrometheusServer != "" {
-		promPlugin, err := collector.NewPrometheusCollectorPlugin(client, o.PrometheusServer)
+		promClientConfig, err := o.prometheusClientConfig()
+		if err != nil {
+			return err
+		}
+
+		promPlugin, err := collector.NewPrometheusCollectorPlugin(client, o.PrometheusServer, promClientConfig)
 		if err != nil {
 			return fmt.Errorf("failed to initialize prometheus collector plugin: %v", err)
 		}
@@ -126,6 +253,13 @@ func (o AdapterServerOptions) RunCustomMetricsAdapterServer(stopCh <-chan struct
 			return fmt.Errorf("failed to register prometheus collector plugin: %v", err)
 		}
 
+		err = collectorFactory.RegisterPodsCollector("prometheus", promPlugin)
+		if err != nil {
+			return fmt.Errorf("failed to register prometheus collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.PrometheusQueryMetric}, promPlugin)
+
 		// skipper collector can only be enabled if prometheus is.
 		if o.SkipperIngressMetrics {
 			skipperPlugin, err := collector.NewSkipperCollectorPlugin(client, promPlugin)
@@ -146,6 +280,53 @@ func (o AdapterServerOptions) RunCustomMetricsAdapterServer(stopCh <-chan struct
 		return fmt.Errorf("failed to register skipper collector plugin: %v", err)
 	}
 
+	// register generic json-path object collector
+	err = collectorFactory.RegisterObjectCollector("", "json-path", collector.NewJSONPathObjectCollectorPlugin(client, o.JSONPathAllowedHosts))
+	if err != nil {
+		return fmt.Errorf("failed to register json-path object collector plugin: %v", err)
+	}
+
+	// register query collectors for InfluxDB, Graphite and Elasticsearch
+	if o.InfluxDBServer != "" || len(o.InfluxDBAllowedHosts) > 0 {
+		influxDBPlugin := collector.NewInfluxDBCollectorPlugin(client, o.InfluxDBServer, o.InfluxDBAllowedHosts)
+		err = collectorFactory.RegisterObjectCollector("", "influxdb", influxDBPlugin)
+		if err != nil {
+			return fmt.Errorf("failed to register influxdb collector plugin: %v", err)
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.InfluxDBQueryMetric}, influxDBPlugin)
+	}
+
+	if o.GraphiteServer != "" || len(o.GraphiteAllowedHosts) > 0 {
+		graphitePlugin := collector.NewGraphiteCollectorPlugin(client, o.GraphiteServer, o.GraphiteAllowedHosts)
+		err = collectorFactory.RegisterObjectCollector("", "graphite", graphitePlugin)
+		if err != nil {
+			return fmt.Errorf("failed to register graphite collector plugin: %v", err)
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.GraphiteQueryMetric}, graphitePlugin)
+	}
+
+	if o.ElasticsearchServer != "" || len(o.ElasticsearchAllowedHosts) > 0 {
+		elasticsearchPlugin := collector.NewElasticsearchCollectorPlugin(client, o.ElasticsearchServer, o.ElasticsearchAllowedHosts)
+		err = collectorFactory.RegisterObjectCollector("", "elasticsearch", elasticsearchPlugin)
+		if err != nil {
+			return fmt.Errorf("failed to register elasticsearch collector plugin: %v", err)
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.ElasticsearchQueryMetric}, elasticsearchPlugin)
+	}
+
+	if o.HTTPJSONExternalMetrics {
+		if len(o.HTTPJSONAllowedHosts) == 0 {
+			return fmt.Errorf("--http-json-allowed-hosts must be set to enable HTTP JSON external metrics")
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.HTTPJSONMetric}, collector.NewHTTPJSONCollectorPlugin(client, o.HTTPJSONAllowedHosts))
+	}
+
+	// register service endpoints collector
+	err = collectorFactory.RegisterObjectCollector("Service", "service-endpoints", collector.NewServiceEndpointsCollectorPlugin(client))
+	if err != nil {
+		return fmt.Errorf("failed to register service-endpoints collector plugin: %v", err)
+	}
+
 	awsSessions := make(map[string]*session.Session, len(o.AWSRegions))
 	for _, region := range o.AWSRegions {
 		awsSessions[region], err = session.NewSession(&aws.Config{Region: aws.String(region)})
@@ -158,7 +339,79 @@ func (o AdapterServerOptions) RunCustomMetricsAdapterServer(stopCh <-chan struct
 		collectorFactory.RegisterExternalCollector([]string{collector.AWSSQSQueueLengthMetric}, collector.NewAWSCollectorPlugin(awsSessions))
 	}
 
-	hpaProvider := provider.NewHPAProvider(client, 30*time.Second, 1*time.Minute, collectorFactory)
+	if o.RabbitMQExternalMetrics {
+		collectorFactory.RegisterExternalCollector(collector.RabbitMQMetrics, collector.NewRabbitMQCollectorPlugin(client, o.RabbitMQURL))
+	}
+
+	if o.RedisExternalMetrics {
+		collectorFactory.RegisterExternalCollector(collector.RedisMetrics, collector.NewRedisCollectorPlugin(client, o.RedisAddress))
+	}
+
+	if o.NATSExternalMetrics {
+		natsCredentials, err := o.natsCredentials()
+		if err != nil {
+			return err
+		}
+
+		collectorFactory.RegisterExternalCollector(collector.NATSMetrics, collector.NewNATSCollectorPlugin(client, o.NATSServer, natsCredentials))
+	}
+
+	if o.SQLDatasourcesFile != "" {
+		datasources, err := collector.LoadSQLDatasources(o.SQLDatasourcesFile)
+		if err != nil {
+			return err
+		}
+
+		sqlPlugin, err := collector.NewSQLCollectorPlugin(datasources)
+		if err != nil {
+			return fmt.Errorf("failed to initialize sql collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.SQLQueryMetric}, sqlPlugin)
+	}
+
+	if o.KafkaExternalMetrics {
+		kafkaConfig, err := o.kafkaConfig()
+		if err != nil {
+			return err
+		}
+
+		kafkaPlugin, err := collector.NewKafkaCollectorPlugin(client, kafkaConfig)
+		if err != nil {
+			return fmt.Errorf("failed to initialize kafka collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.KafkaConsumerGroupLagMetric}, kafkaPlugin)
+	}
+
+	schedulerConfig := provider.CollectorSchedulerConfig{
+		Workers:            o.CollectorWorkers,
+		BackendConcurrency: o.BackendConcurrency,
+		BackendRateLimit:   o.BackendRateLimit,
+		BreakerThreshold:   o.CircuitBreakerThreshold,
+		BreakerTimeout:     o.CircuitBreakerTimeout,
+	}
+
+	var coordinator provider.Coordinator
+	if o.CoordinationMode != "" {
+		coordinator, err = o.coordinator(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	persistence, err := o.storePersistence(client)
+	if err != nil {
+		return err
+	}
+
+	mapper, err := dynamicmapper.NewRESTMapper(client.Discovery(), o.DiscoveryInterval)
+	if err != nil {
+		return fmt.Errorf("failed to initialize discovery rest mapper: %v", err)
+	}
+	mapper.RunUntil(stopCh)
+
+	hpaProvider := provider.NewHPAProvider(client, mapper, 30*time.Second, 1*time.Minute, collectorFactory, schedulerConfig, coordinator, persistence)
 
 	// convert stop channel to a context
 	ctx, cancel := context.WithCancel(context.Background())
@@ -167,7 +420,26 @@ func (o AdapterServerOptions) RunCustomMetricsAdapterServer(stopCh <-chan struct
 		cancel()
 	}()
 
-	go hpaProvider.Run(ctx)
+	providerStopped := make(chan struct{})
+	go func() {
+		defer close(providerStopped)
+		hpaProvider.Run(ctx)
+	}()
+
+	// wait for the provider to save the last snapshot of the metric store
+	// and to release the leader election lock before the process exits.
+	defer func() {
+		cancel()
+		<-providerStopped
+	}()
+
+	if o.DebugAddress != "" {
+		go serveAPI(ctx, "debug API", o.debugListenAddress(), hpaProvider.DebugHandler())
+	}
+
+	if coordinator != nil {
+		go serveAPI(ctx, "peer sync API", o.coordinationListenAddress(), hpaProvider.PeerHandler())
+	}
 
 	customMetricsProvider := hpaProvider
 	externalMetricsProvider := hpaProvider
@@ -195,13 +467,91 @@ type AdapterServerOptions struct {
 
 	// RemoteKubeConfigFile is the config used to list pods from the master API server
 	RemoteKubeConfigFile string
+	// DiscoveryInterval is the interval at which the API discovery
+	// information is refreshed
+	DiscoveryInterval time.Duration
 	// EnableCustomMetricsAPI switches on sample apiserver for Custom Metrics API
 	EnableCustomMetricsAPI bool
 	// EnableExternalMetricsAPI switches on sample apiserver for External Metrics API
 	EnableExternalMetricsAPI bool
+	// CollectorWorkers is the number of collectors running at the same
+	// time.
+	CollectorWorkers int
+	// BackendConcurrency is the maximum number of collectors running at
+	// the same time against the same backend.
+	BackendConcurrency int
+	// BackendRateLimit is the maximum number of collector runs per second
+	// against the same backend.
+	BackendRateLimit float64
+	// CircuitBreakerThreshold is the number of consecutive failures of a
+	// backend after which its collectors are paused.
+	CircuitBreakerThreshold int
+	// CircuitBreakerTimeout is the time the collectors of a failing
+	// backend are paused.
+	CircuitBreakerTimeout time.Duration
+	// CoordinationMode defines how the replicas split the collection,
+	// either leader or sharded.
+	CoordinationMode string
+	// CoordinationNamespace is the namespace of the leader election
+	// Lease and the adapter service.
+	CoordinationNamespace string
+	// CoordinationName is the name of the leader election Lease and
+	// the adapter service.
+	CoordinationName string
+	// CoordinationAddress is the address the collected metrics are served
+	// to the other replicas on.
+	CoordinationAddress string
+	// CoordinationTokenFile is the file containing the token the replicas
+	// authenticate to each other with.
+	CoordinationTokenFile string
+	// PodIP is the IP of the adapter pod.
+	PodIP string
+	// StoreSnapshotFile is the file snapshots of the metric store are
+	// saved to.
+	StoreSnapshotFile string
+	// StoreSnapshotConfigMap is the ConfigMap snapshots of the metric
+	// store are saved to.
+	StoreSnapshotConfigMap string
+	// DebugAddress is the address of the read-only debug API.
+	DebugAddress string
 	// PrometheusServer enables prometheus queries to the specified
 	// server.
 	PrometheusServer string
+	// PrometheusCAFile is a CA bundle used to verify the prometheus server
+	// certificate.
+	PrometheusCAFile string
+	// PrometheusCertFile and PrometheusKeyFile is a client certificate
+	// used to authenticate to the prometheus server.
+	PrometheusCertFile string
+	PrometheusKeyFile  string
+	// PrometheusInsecureSkipVerify disables verification of the prometheus
+	// server certificate.
+	PrometheusInsecureSkipVerify bool
+	// PrometheusBearerTokenFile is a file containing a bearer token for
+	// the prometheus server.
+	PrometheusBearerTokenFile string
+	// PrometheusUsername and PrometheusPasswordFile are used for basic auth
+	// to the prometheus server.
+	PrometheusUsername     string
+	PrometheusPasswordFile string
+	// PrometheusHeaders are extra headers sent to the prometheus server in
+	// the format <name>=<value>.
+	PrometheusHeaders []string
+	// JSONPathAllowedHosts are the hosts which json-path object metrics
+	// may request with an explicit url.
+	JSONPathAllowedHosts []string
+	// InfluxDBServer, GraphiteServer and ElasticsearchServer are the
+	// default servers used by the query collectors.
+	InfluxDBServer      string
+	GraphiteServer      string
+	ElasticsearchServer string
+	// InfluxDBAllowedHosts, GraphiteAllowedHosts and
+	// ElasticsearchAllowedHosts are the hosts of servers which HPAs may
+	// define. A query collector is only enabled if it has a default server
+	// or allowed hosts.
+	InfluxDBAllowedHosts      []string
+	GraphiteAllowedHosts      []string
+	ElasticsearchAllowedHosts []string
 	// SkipperIngressMetrics switches on support for skipper ingress based
 	// metric collection.
 	SkipperIngressMetrics bool
@@ -210,4 +560,227 @@ type AdapterServerOptions struct {
 	AWSExternalMetrics bool
 	// AWSRegions the AWS regions which are supported for monitoring.
 	AWSRegions []string
+	// HTTPJSONExternalMetrics switches on support for getting external
+	// metrics from JSON HTTP endpoints.
+	HTTPJSONExternalMetrics bool
+	// HTTPJSONAllowedHosts are the hosts which HTTP JSON external metrics
+	// may request.
+	HTTPJSONAllowedHosts []string
+	// RabbitMQExternalMetrics switches on support for getting external
+	// metrics from RabbitMQ.
+	RabbitMQExternalMetrics bool
+	// RabbitMQURL is the default url of the RabbitMQ management API.
+	RabbitMQURL string
+	// RedisExternalMetrics switches on support for getting external
+	// metrics from Redis.
+	RedisExternalMetrics bool
+	// RedisAddress is the default address of the Redis server.
+	RedisAddress string
+	// NATSExternalMetrics switches on support for getting external metrics
+	// from NATS JetStream.
+	NATSExternalMetrics bool
+	// NATSServer is the default url of the NATS server.
+	NATSServer string
+	// NATSTokenFile is a file containing the default token for the NATS
+	// server.
+	NATSTokenFile string
+	// NATSUsername and NATSPasswordFile are the default username and
+	// password for the NATS server.
+	NATSUsername     string
+	NATSPasswordFile string
+	// SQLDatasourcesFile is a file defining the datasources which can be
+	// queried by SQL external metrics.
+	SQLDatasourcesFile string
+	// KafkaExternalMetrics switches on support for getting external
+	// metrics from Kafka.
+	KafkaExternalMetrics bool
+	// KafkaBrokers are the default Kafka brokers.
+	KafkaBrokers []string
+	// KafkaVersion is the Kafka version of the brokers.
+	KafkaVersion string
+	// KafkaTLS enables TLS for connections to the Kafka brokers.
+	KafkaTLS bool
+	// KafkaCAFile, KafkaCertFile and KafkaKeyFile configure TLS for
+	// connections to the Kafka brokers.
+	KafkaCAFile             string
+	KafkaCertFile           string
+	KafkaKeyFile            string
+	KafkaInsecureSkipVerify bool
+	// KafkaSASLUsername and KafkaSASLPasswordFile are used for SASL/PLAIN
+	// authentication to the Kafka brokers.
+	KafkaSASLUsername     string
+	KafkaSASLPasswordFile string
+}
+
+// serveAPI serves an auxiliary HTTP API until the context is canceled.
+func serveAPI(ctx context.Context, name, address string, handler http.Handler) {
+	server := &http.Server{
+		Addr:    address,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		err := server.Close()
+		if err != nil {
+			glog.Errorf("Failed to stop %s: %v", name, err)
+		}
+	}()
+
+	glog.Infof("Serving %s on %s", name, address)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		glog.Errorf("Failed to serve %s: %v", name, err)
+	}
+}
+
+// prometheusClientConfig returns the prometheus client config defined by the
+// options.
+func (o AdapterServerOptions) prometheusClientConfig() (*collector.PrometheusClientConfig, error) {
+	headers := make(map[string]string, len(o.PrometheusHeaders))
+	for _, header := range o.PrometheusHeaders {
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid prometheus header '%s', expected <name>=<value>", header)
+		}
+		headers[parts[0]] = parts[1]
+	}
+
+	return &collector.PrometheusClientConfig{
+		CAFile:             o.PrometheusCAFile,
+		CertFile:           o.PrometheusCertFile,
+		KeyFile:            o.PrometheusKeyFile,
+		InsecureSkipVerify: o.PrometheusInsecureSkipVerify,
+		BearerTokenFile:    o.PrometheusBearerTokenFile,
+		Username:           o.PrometheusUsername,
+		PasswordFile:       o.PrometheusPasswordFile,
+		Headers:            headers,
+	}, nil
+}
+
+// kafkaConfig returns the Kafka config defined by the options.
+func (o AdapterServerOptions) kafkaConfig() (*collector.KafkaConfig, error) {
+	config := &collector.KafkaConfig{
+		Brokers:            o.KafkaBrokers,
+		Version:            o.KafkaVersion,
+		TLS:                o.KafkaTLS,
+		CAFile:             o.KafkaCAFile,
+		CertFile:           o.KafkaCertFile,
+		KeyFile:            o.KafkaKeyFile,
+		InsecureSkipVerify: o.KafkaInsecureSkipVerify,
+		SASLUsername:       o.KafkaSASLUsername,
+	}
+
+	if o.KafkaSASLPasswordFile != "" {
+		password, err := ioutil.ReadFile(o.KafkaSASLPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kafka sasl password file: %v", err)
+		}
+		config.SASLPassword = strings.TrimSpace(string(password))
+	}
+
+	return config, nil
+}
+
+// coordinator returns the coordinator of the adapter replicas defined by the
+// options. The replica is reachable on the pod IP and the port of the
+// coordination address.
+func (o AdapterServerOptions) coordinator(client kubernetes.Interface) (provider.Coordinator, error) {
+	if o.PodIP == "" {
+		return nil, fmt.Errorf("no pod IP defined, required for coordination mode '%s'", o.CoordinationMode)
+	}
+
+	_, port, err := net.SplitHostPort(o.CoordinationAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid coordination address '%s': %v", o.CoordinationAddress, err)
+	}
+
+	if o.CoordinationTokenFile == "" {
+		return nil, fmt.Errorf("no token file defined, required for coordination mode '%s'", o.CoordinationMode)
+	}
+
+	data, err := ioutil.ReadFile(o.CoordinationTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coordination token file: %v", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return nil, fmt.Errorf("coordination token file %s is empty", o.CoordinationTokenFile)
+	}
+
+	return provider.NewCoordinator(client, provider.CoordinationConfig{
+		Mode:      o.CoordinationMode,
+		Namespace: o.CoordinationNamespace,
+		Name:      o.CoordinationName,
+		Address:   net.JoinHostPort(o.PodIP, port),
+		Token:     token,
+	})
+}
+
+// coordinationListenAddress returns the address the collected metrics are
+// served to the other replicas on. Unless a host is defined, they are only
+// served on the pod IP instead of all interfaces.
+func (o AdapterServerOptions) coordinationListenAddress() string {
+	host, port, err := net.SplitHostPort(o.CoordinationAddress)
+	if err != nil || host != "" {
+		return o.CoordinationAddress
+	}
+	return net.JoinHostPort(o.PodIP, port)
+}
+
+// debugListenAddress returns the address the debug API is served on. The
+// debug API is unauthenticated, so it's served on localhost only unless the
+// host is set explicitly.
+func (o AdapterServerOptions) debugListenAddress() string {
+	host, port, err := net.SplitHostPort(o.DebugAddress)
+	if err != nil || host != "" {
+		return o.DebugAddress
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+// storePersistence returns the persistence of the metric store defined by the
+// options or nil if the metric store is not persisted.
+func (o AdapterServerOptions) storePersistence(client kubernetes.Interface) (provider.StorePersistence, error) {
+	switch {
+	case o.StoreSnapshotFile != "" && o.StoreSnapshotConfigMap != "":
+		return nil, fmt.Errorf("only one of --store-snapshot-file and --store-snapshot-configmap can be set")
+	case o.StoreSnapshotFile != "":
+		return provider.NewFilePersistence(o.StoreSnapshotFile), nil
+	case o.StoreSnapshotConfigMap != "":
+		parts := strings.Split(o.StoreSnapshotConfigMap, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid store snapshot ConfigMap '%s', expected <namespace>/<name>", o.StoreSnapshotConfigMap)
+		}
+		return provider.NewConfigMapPersistence(client, parts[0], parts[1]), nil
+	default:
+		return nil, nil
+	}
+}
+
+// natsCredentials returns the default NATS credentials defined by the
+// options.
+func (o AdapterServerOptions) natsCredentials() (collector.NATSCredentials, error) {
+	credentials := collector.NATSCredentials{
+		Username: o.NATSUsername,
+	}
+
+	if o.NATSTokenFile != "" {
+		token, err := ioutil.ReadFile(o.NATSTokenFile)
+		if err != nil {
+			return credentials, fmt.Errorf("failed to read nats token file: %v", err)
+		}
+		credentials.Token = strings.TrimSpace(string(token))
+	}
+
+	if o.NATSPasswordFile != "" {
+		password, err := ioutil.ReadFile(o.NATSPasswordFile)
+		if err != nil {
+			return credentials, fmt.Errorf("failed to read nats password file: %v", err)
+		}
+		credentials.Password = strings.TrimSpace(string(password))
+	}
+
+	return credentials, nil
 }